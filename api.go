@@ -1,8 +1,7 @@
 package storm
 
 import (
-	"crypto/subtle"
-	"encoding/base64"
+	"context"
 	"fmt"
 	deluge "github.com/gdm85/go-libdeluge"
 	"github.com/gorilla/mux"
@@ -29,52 +28,157 @@ func appendSuffix(s, suffix string) string {
 	return fmt.Sprint(s, suffix)
 }
 
-func New(log *zap.Logger, pool *ConnectionPool, pathPrefix string, apiKey string, development bool) *Api {
+// Config configures an Api.
+type Config struct {
+	// PathPrefix is the base URL path that the Api responds to
+	PathPrefix string
+	// ApiKey enables authentication when set
+	ApiKey string
+	// Development serves the frontend from the filesystem instead of the embedded source
+	Development bool
+	// Users enables authentication using user accounts when set
+	Users *UserStore
+	// Tokens enables authentication using API tokens when set
+	Tokens *TokenStore
+	// Webhooks configures outgoing webhooks on torrent lifecycle events
+	Webhooks WebhookConfig
+	// ForwardAuth enables authentication by a reverse proxy
+	ForwardAuth ForwardAuthConfig
+	// TrustedProxies are the addresses of reverse proxies that are trusted to authenticate users
+	TrustedProxies TrustedProxies
+	// Lockout configures locking out clients after failed authentication attempts
+	Lockout LockoutConfig
+	// RateLimit configures the per-client request rate limit
+	RateLimit RateLimitConfig
+	// GeoIP looks up the country of peers if the Deluge daemon does not report it
+	GeoIP *GeoIP
+	// MoveRoots are the paths on the Deluge daemon hosts that torrents can be moved to
+	MoveRoots []string
+	// SessionExpiry is how long a login session lasts, defaults to DefaultSessionExpiry
+	SessionExpiry time.Duration
+	// Rules configures automatically pausing or removing torrents
+	Rules RulesConfig
+	// Feeds enables adding torrents from RSS and Atom feeds when set
+	Feeds *FeedStore
+	// Watch configures folders that torrent and magnet files are added from
+	Watch WatchConfig
+}
+
+func New(log *zap.Logger, daemons *Daemons, config Config) *Api {
 	api := &Api{
-		pool:       pool,
-		pathPrefix: strings.TrimSuffix(pathPrefix, "/"),
-		apiKey:     apiKey,
-		log:        log,
-		router:     mux.NewRouter(),
+		daemons:        daemons,
+		pathPrefix:     strings.TrimSuffix(config.PathPrefix, "/"),
+		apiKey:         config.ApiKey,
+		users:          config.Users,
+		tokens:         config.Tokens,
+		sessions:       NewSessions(config.SessionExpiry),
+		forwardAuth:    config.ForwardAuth,
+		trustedProxies: config.TrustedProxies,
+		geoIP:          config.GeoIP,
+		lockout:        NewLockout(config.Lockout),
+		rateLimiter:    NewRateLimiter(config.RateLimit),
+		log:            log,
+		router:         mux.NewRouter(),
+		metrics:        NewMetrics(),
+	}
+
+	api.stream = NewViewStream(log.Named("stream"), ViewStreamInterval, func(ctx context.Context) (*ViewUpdate, error) {
+		return api.view(ctx, api.daemons.Names(), new(viewRequest))
+	})
+
+	api.moves = NewMoves(log.Named("moves"), config.MoveRoots)
+
+	api.webhooks = NewWebhooks(log.Named("webhooks"), api.stream, config.Webhooks)
+	if len(config.Webhooks.URLs) > 0 {
+		api.webhooks.Start()
+	}
+
+	if config.Rules.Store != nil {
+		api.rules = NewRuleEngine(log.Named("rules"), daemons, config.Rules)
+		api.rules.Start()
+	}
+
+	if config.Feeds != nil {
+		api.feeds = NewFeedWatcher(log.Named("feeds"), daemons, config.Feeds)
+		api.feeds.Start()
+	}
+
+	if len(config.Watch.Folders) > 0 {
+		api.watcher = NewWatcher(log.Named("watch"), daemons, config.Watch)
+		api.watcher.Start()
 	}
 
 	api.router.NotFoundHandler = api.httpNotFound()
-	api.bind(development)
+	api.bind(config.Development)
 
 	return api
 }
 
 type Api struct {
-	pool       *ConnectionPool
+	daemons    *Daemons
 	pathPrefix string
 	apiKey     string
+	users      *UserStore
+	tokens     *TokenStore
+	sessions   *Sessions
+
+	forwardAuth       ForwardAuthConfig
+	forwardAuthWarned forwardAuthWarnings
+	trustedProxies    TrustedProxies
+	lockout           *Lockout
+	rateLimiter       *RateLimiter
+	geoIP             *GeoIP
+
+	log      *zap.Logger
+	router   *mux.Router
+	stream   *ViewStream
+	metrics  *Metrics
+	webhooks *Webhooks
+	moves    *Moves
+	rules    *RuleEngine
+	feeds    *FeedWatcher
+	watcher  *Watcher
+}
 
-	log    *zap.Logger
-	router *mux.Router
+// Close stops any background processing started by the Api.
+func (api *Api) Close() {
+	api.webhooks.Stop()
+	api.moves.Close()
+	if api.rules != nil {
+		api.rules.Stop()
+	}
+	if api.feeds != nil {
+		api.feeds.Stop()
+	}
+	if api.watcher != nil {
+		api.watcher.Stop()
+	}
 }
 
-func (api *Api) DelugeHandler(f DelugeMethod) http.HandlerFunc {
+// Handler returns an http.HandlerFunc that sends the result of f.
+func (api *Api) Handler(f HandlerFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		_ = Handle(rw, r, func(r *http.Request) (interface{}, error) {
-			conn, err := api.pool.Get(r.Context())
-			if err != nil {
-				return nil, err
-			}
-
-			ret, err := f(conn, r)
-			api.pool.Put(conn)
+		_ = Handle(rw, r, f)
+	}
+}
 
-			switch t := err.(type) {
-			case deluge.RPCError:
-				err = RPCError{
-					ExceptionType:    t.ExceptionType,
-					ExceptionMessage: t.ExceptionMessage,
-				}
-			}
+// DelugeHandler returns an http.HandlerFunc that calls f with a connection to the Deluge daemon selected by the request.
+// The daemon is selected by the {daemon} route variable, or the default daemon if the route does not have one.
+func (api *Api) DelugeHandler(f DelugeMethod) http.HandlerFunc {
+	return api.Handler(func(r *http.Request) (interface{}, error) {
+		pool, err := api.daemons.Get(mux.Vars(r)["daemon"])
+		if err != nil {
+			return nil, err
+		}
 
-			return ret, err
+		var ret interface{}
+		err = pool.Do(r.Context(), func(conn deluge.DelugeClient) (err error) {
+			ret, err = f(conn, r)
+			return
 		})
-	}
+
+		return ret, rpcError(err)
+	})
 }
 
 func (api *Api) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -168,26 +272,6 @@ func (api *Api) bindStatic(router *mux.Router, development bool) {
 	router.Methods(http.MethodGet).Handler(fileServer)
 }
 
-// keyFromRequest attempts to locate the API key from the incoming request.
-// It looks for the key using these methods in the following order:
-//   - The password component of a Basic auth header
-//   - The cookie value ApiAuthCookieName as a base64 encoded value
-func (api *Api) keyFromRequest(r *http.Request) (string, bool) {
-	_, password, ok := r.BasicAuth()
-	if ok {
-		return password, false
-	}
-
-	fromCookie, err := r.Cookie(ApiAuthCookieName)
-	if err != nil {
-		return "", false
-	}
-
-	fromCookieDecoded, _ := base64.StdEncoding.DecodeString(fromCookie.Value)
-
-	return string(fromCookieDecoded), true
-}
-
 // logForRequest takes a WrappedResponse and an incoming HTTP request and logs it
 func (api *Api) logForRequest(rw *WrappedResponse, r *http.Request) {
 	logger := api.log.With(
@@ -223,42 +307,6 @@ func (api *Api) httpMiddlewareLog(next http.Handler) http.Handler {
 	})
 }
 
-func (api *Api) httpMiddlewareAuthenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		apiKey, fromCookie := api.keyFromRequest(r)
-		if apiKey == "" {
-			SendError(rw, &Error{
-				Code:    http.StatusUnauthorized,
-				Message: "No authentication provided in request",
-			})
-			return
-		}
-
-		ok := subtle.ConstantTimeCompare([]byte(api.apiKey), []byte(apiKey)) == 1
-		if !ok {
-			SendError(rw, &Error{
-				Code:    http.StatusUnauthorized,
-				Message: "Incorrect API key",
-			})
-			return
-		}
-
-		// If the request didn't originate from a cookie, then set the cookie
-		if !fromCookie {
-			http.SetCookie(rw, &http.Cookie{
-				Name:     ApiAuthCookieName,
-				Value:    base64.StdEncoding.EncodeToString([]byte(apiKey)),
-				Path:     fmt.Sprintf("%s/api", api.pathPrefix),
-				Expires:  time.Now().Add(time.Hour * 24 * 365),
-				SameSite: http.SameSiteStrictMode,
-				HttpOnly: true,
-			})
-		}
-
-		next.ServeHTTP(rw, r)
-	})
-}
-
 func (api *Api) bind(development bool) {
 	primaryRouter := api.router
 	if api.pathPrefix != "" {
@@ -273,13 +321,24 @@ func (api *Api) bind(development bool) {
 		rw.WriteHeader(http.StatusOK)
 	})
 
+	// Login and logout are the only routes that do not require authentication
+	sessionRouter := router.NewRoute().Subrouter()
+	sessionRouter.Use(api.httpMiddlewareLog, api.httpMiddlewareMetrics, api.httpMiddlewareRateLimit)
+
+	sessionRouter.
+		Methods(http.MethodPost).
+		Path("/login").
+		HandlerFunc(api.httpLogin)
+	sessionRouter.
+		Methods(http.MethodPost).
+		Path("/logout").
+		HandlerFunc(api.httpLogout)
+
 	apiRouter := router.NewRoute().Subrouter()
-	apiRouter.Use(api.httpMiddlewareLog)
+	apiRouter.Use(api.httpMiddlewareLog, api.httpMiddlewareMetrics, api.httpMiddlewareRateLimit)
 
-	// Enable API level authentication
-	if api.apiKey != "" {
-		apiRouter.Use(api.httpMiddlewareAuthenticate)
-	}
+	// Authenticate requests if enabled, otherwise requests are made as an anonymous admin
+	apiRouter.Use(api.httpMiddlewareAuthenticate)
 
 	apiRouter.
 		Methods(http.MethodGet).
@@ -290,101 +349,287 @@ func (api *Api) bind(development bool) {
 
 	apiRouter.
 		Methods(http.MethodGet).
-		Path("/session").
-		HandlerFunc(api.DelugeHandler(httpGetSessionStatus))
+		Path("/whoami").
+		HandlerFunc(api.Handler(api.httpWhoAmI))
+
+	if api.users != nil {
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/users").
+			HandlerFunc(api.authorize(PermUsersAdmin, api.Handler(api.httpUsers)))
+		apiRouter.
+			Methods(http.MethodPost).
+			Path("/users").
+			HandlerFunc(api.authorize(PermUsersAdmin, api.Handler(api.httpCreateUser)))
+		apiRouter.
+			Methods(http.MethodPut).
+			Path("/users/{name}").
+			HandlerFunc(api.authorize(PermUsersAdmin, api.Handler(api.httpUpdateUser)))
+		apiRouter.
+			Methods(http.MethodDelete).
+			Path("/users/{name}").
+			HandlerFunc(api.authorize(PermUsersAdmin, api.Handler(api.httpDeleteUser)))
+	}
+
+	if api.tokens != nil {
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/tokens").
+			HandlerFunc(api.authorize(PermTokensAdmin, api.Handler(api.httpTokens)))
+		apiRouter.
+			Methods(http.MethodPost).
+			Path("/tokens").
+			HandlerFunc(api.authorize(PermTokensAdmin, api.Handler(api.httpCreateToken)))
+		apiRouter.
+			Methods(http.MethodDelete).
+			Path("/tokens/{id}").
+			HandlerFunc(api.authorize(PermTokensAdmin, api.Handler(api.httpRevokeToken)))
+	}
 
 	apiRouter.
 		Methods(http.MethodGet).
-		Path("/disk/free").
-		HandlerFunc(api.DelugeHandler(httpGetFreeSpace))
+		Path("/daemons").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.Handler(api.httpDaemons)))
 
 	apiRouter.
 		Methods(http.MethodGet).
-		Path("/view").
-		HandlerFunc(api.DelugeHandler(httpViewUpdate))
+		Path("/view/stream").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.httpViewStream))
 
 	apiRouter.
 		Methods(http.MethodGet).
-		Path("/plugins").
-		HandlerFunc(api.DelugeHandler(httpGetPlugins))
+		Path("/webhooks/deliveries").
+		HandlerFunc(api.authorize(PermWebhooksRead, api.Handler(api.httpWebhookDeliveries)))
 
 	apiRouter.
+		Methods(http.MethodGet).
+		Path("/moves").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.Handler(api.httpMoves)))
+	apiRouter.
+		Methods(http.MethodGet).
+		Path("/moves/{id}").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.Handler(api.httpMove)))
+
+	if api.rules != nil {
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/rules").
+			HandlerFunc(api.authorize(PermRulesRead, api.Handler(api.httpRules)))
+		apiRouter.
+			Methods(http.MethodPost).
+			Path("/rules").
+			HandlerFunc(api.authorize(PermRulesAdmin, api.Handler(api.httpCreateRule)))
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/rules/audit").
+			HandlerFunc(api.authorize(PermRulesRead, api.Handler(api.httpRulesAudit)))
+		apiRouter.
+			Methods(http.MethodPost).
+			Path("/rules/run").
+			HandlerFunc(api.authorize(PermRulesAdmin, api.Handler(api.httpRunRules)))
+		apiRouter.
+			Methods(http.MethodPut).
+			Path("/rules/{id}").
+			HandlerFunc(api.authorize(PermRulesAdmin, api.Handler(api.httpUpdateRule)))
+		apiRouter.
+			Methods(http.MethodDelete).
+			Path("/rules/{id}").
+			HandlerFunc(api.authorize(PermRulesAdmin, api.Handler(api.httpDeleteRule)))
+	}
+
+	if api.feeds != nil {
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/feeds").
+			HandlerFunc(api.authorize(PermFeedsRead, api.Handler(api.httpFeeds)))
+		apiRouter.
+			Methods(http.MethodPost).
+			Path("/feeds").
+			HandlerFunc(api.authorize(PermFeedsWrite, api.Handler(api.httpCreateFeed)))
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/feeds/history").
+			HandlerFunc(api.authorize(PermFeedsRead, api.Handler(api.httpFeedHistory)))
+		apiRouter.
+			Methods(http.MethodPut).
+			Path("/feeds/{id}").
+			HandlerFunc(api.authorize(PermFeedsWrite, api.Handler(api.httpUpdateFeed)))
+		apiRouter.
+			Methods(http.MethodDelete).
+			Path("/feeds/{id}").
+			HandlerFunc(api.authorize(PermFeedsWrite, api.Handler(api.httpDeleteFeed)))
+		apiRouter.
+			Methods(http.MethodPost).
+			Path("/feeds/{id}/poll").
+			HandlerFunc(api.authorize(PermFeedsWrite, api.Handler(api.httpPollFeed)))
+	}
+
+	if api.watcher != nil {
+		apiRouter.
+			Methods(http.MethodGet).
+			Path("/watch/results").
+			HandlerFunc(api.authorize(PermWatchRead, api.Handler(api.httpWatchResults)))
+	}
+
+	// Routes on the default daemon
+	api.bindDeluge(apiRouter)
+
+	// Routes on a specific daemon
+	api.bindDeluge(apiRouter.PathPrefix("/daemons/{daemon}").Subrouter())
+
+	// Prometheus metrics
+	metricsRouter := primaryRouter.NewRoute().Subrouter()
+	metricsRouter.Use(api.httpMiddlewareRateLimit, api.httpMiddlewareAuthenticate)
+
+	metricsRouter.
+		Methods(http.MethodGet).
+		Path("/metrics").
+		HandlerFunc(api.authorize(PermMetricsRead, api.httpMetrics))
+
+	// Static files
+	api.bindStatic(primaryRouter, development)
+}
+
+// bindDeluge binds the routes that are handled by a Deluge daemon to router.
+func (api *Api) bindDeluge(router *mux.Router) {
+	router.
+		Methods(http.MethodGet).
+		Path("/session").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(httpGetSessionStatus)))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/disk/free").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(httpGetFreeSpace)))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/view").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.Handler(api.httpViewUpdate)))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/plugins").
+		HandlerFunc(api.authorize(PermPluginsRead, api.DelugeHandler(httpGetPlugins)))
+
+	router.
 		Methods(http.MethodPost).
 		Path("/plugins/{id}").
-		HandlerFunc(api.DelugeHandler(httpEnablePlugin))
+		HandlerFunc(api.authorize(PermPluginsWrite, api.DelugeHandler(httpEnablePlugin)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodDelete).
 		Path("/plugins/{id}").
-		HandlerFunc(api.DelugeHandler(httpDisablePlugin))
+		HandlerFunc(api.authorize(PermPluginsWrite, api.DelugeHandler(httpDisablePlugin)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodGet).
 		Path("/torrents").
-		HandlerFunc(api.DelugeHandler(httpTorrentsStatus))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(httpTorrentsStatus)))
+	router.
 		Methods(http.MethodPost).
 		Path("/torrents").
-		HandlerFunc(api.DelugeHandler(httpAddTorrent))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsAdd, api.DelugeHandler(httpAddTorrent)))
+	router.
+		Methods(http.MethodPut).
+		Path("/torrents").
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(httpSetTorrentsOptions)))
+	router.
 		Methods(http.MethodDelete).
 		Path("/torrents").
-		HandlerFunc(api.DelugeHandler(httpDeleteTorrents))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsDelete, api.DelugeHandler(httpDeleteTorrents)))
+	router.
+		Methods(http.MethodPost).
+		Path("/torrents/inspect").
+		HandlerFunc(api.authorize(PermTorrentsAdd, api.DelugeHandler(httpInspectTorrent)))
+	router.
 		Methods(http.MethodPost).
 		Path("/torrents/pause").
-		HandlerFunc(api.DelugeHandler(httpPauseTorrents))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(httpPauseTorrents)))
+	router.
 		Methods(http.MethodPost).
 		Path("/torrents/resume").
-		HandlerFunc(api.DelugeHandler(httpResumeTorrents))
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(httpResumeTorrents)))
+	router.
+		Methods(http.MethodPost).
+		Path("/torrents/reannounce").
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(httpReannounceTorrents)))
+	router.
+		Methods(http.MethodPost).
+		Path("/torrents/trackers/replace").
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(httpReplaceTrackers)))
+	router.
+		Methods(http.MethodPost).
+		Path("/torrents/move").
+		HandlerFunc(api.authorize(PermTorrentsMove, api.Handler(api.httpMoveTorrents)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodGet).
 		Path("/torrent/{id}").
-		HandlerFunc(api.DelugeHandler(TorrentHandler(httpTorrentStatus)))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(TorrentHandler(httpTorrentStatus))))
+	router.
 		Methods(http.MethodDelete).
 		Path("/torrent/{id}").
-		HandlerFunc(api.DelugeHandler(TorrentHandler(httpDeleteTorrent)))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsDelete, api.DelugeHandler(TorrentHandler(httpDeleteTorrent))))
+	router.
 		Methods(http.MethodPut).
 		Path("/torrent/{id}").
-		HandlerFunc(api.DelugeHandler(TorrentHandler(httpSetTorrentOptions)))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(TorrentHandler(httpSetTorrentOptions))))
+	router.
 		Methods(http.MethodPost).
 		Path("/torrent/{id}/pause").
-		HandlerFunc(api.DelugeHandler(TorrentHandler(httpPauseTorrent)))
-	apiRouter.
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(TorrentHandler(httpPauseTorrent))))
+	router.
 		Methods(http.MethodPost).
 		Path("/torrent/{id}/resume").
-		HandlerFunc(api.DelugeHandler(TorrentHandler(httpResumeTorrent)))
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(TorrentHandler(httpResumeTorrent))))
+	router.
+		Methods(http.MethodGet).
+		Path("/torrent/{id}/files").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(TorrentHandler(httpTorrentFiles))))
+	router.
+		Methods(http.MethodGet).
+		Path("/torrent/{id}/peers").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(TorrentHandler(api.httpTorrentPeers))))
+	router.
+		Methods(http.MethodGet).
+		Path("/torrent/{id}/trackers").
+		HandlerFunc(api.authorize(PermTorrentsRead, api.DelugeHandler(TorrentHandler(httpTorrentTrackers))))
+	router.
+		Methods(http.MethodPut).
+		Path("/torrent/{id}/trackers").
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(TorrentHandler(httpSetTorrentTracker))))
+	router.
+		Methods(http.MethodPost).
+		Path("/torrent/{id}/reannounce").
+		HandlerFunc(api.authorize(PermTorrentsControl, api.DelugeHandler(TorrentHandler(httpReannounceTorrent))))
+	router.
+		Methods(http.MethodPost).
+		Path("/torrent/{id}/move").
+		HandlerFunc(api.authorize(PermTorrentsMove, api.Handler(api.httpMoveTorrent)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodGet).
 		Path("/labels").
-		HandlerFunc(api.DelugeHandler(httpLabels))
+		HandlerFunc(api.authorize(PermLabelsRead, api.DelugeHandler(httpLabels)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodPost).
 		Path("/labels/{id}").
-		HandlerFunc(api.DelugeHandler(httpCreateLabel))
+		HandlerFunc(api.authorize(PermLabelsWrite, api.DelugeHandler(httpCreateLabel)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodDelete).
 		Path("/labels/{id}").
-		HandlerFunc(api.DelugeHandler(httpDeleteLabel))
+		HandlerFunc(api.authorize(PermLabelsWrite, api.DelugeHandler(httpDeleteLabel)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodGet).
 		Path("/torrents/labels").
-		HandlerFunc(api.DelugeHandler(httpTorrentsLabels))
+		HandlerFunc(api.authorize(PermLabelsRead, api.DelugeHandler(httpTorrentsLabels)))
 
-	apiRouter.
+	router.
 		Methods(http.MethodPost).
 		Path("/torrent/{id}/label").
-		HandlerFunc(api.DelugeHandler(TorrentHandler(httpSetTorrentLabel)))
-
-	// Static files
-	api.bindStatic(primaryRouter, development)
+		HandlerFunc(api.authorize(PermLabelsWrite, api.DelugeHandler(TorrentHandler(httpSetTorrentLabel))))
 }
@@ -0,0 +1,315 @@
+package storm
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Role is the role of a user which determines the permissions they have.
+type Role string
+
+const (
+	// RoleViewer can view torrents but cannot change anything.
+	RoleViewer Role = "viewer"
+	// RoleOperator can add, pause, resume and remove torrents and manage labels.
+	RoleOperator Role = "operator"
+	// RoleAdmin can do anything, including deleting downloaded files and managing plugins and users.
+	RoleAdmin Role = "admin"
+)
+
+// Valid returns true if r is a known role.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permission is the permission to perform an action on the API.
+type Permission string
+
+const (
+	PermTorrentsRead        Permission = "torrents:read"
+	PermTorrentsAdd         Permission = "torrents:add"
+	PermTorrentsControl     Permission = "torrents:control"
+	PermTorrentsDelete      Permission = "torrents:delete"
+	PermTorrentsDeleteFiles Permission = "torrents:delete-files"
+	PermTorrentsMove        Permission = "torrents:move"
+	PermLabelsRead          Permission = "labels:read"
+	PermLabelsWrite         Permission = "labels:write"
+	PermPluginsRead         Permission = "plugins:read"
+	PermPluginsWrite        Permission = "plugins:write"
+	PermWebhooksRead        Permission = "webhooks:read"
+	PermMetricsRead         Permission = "metrics:read"
+	PermUsersAdmin          Permission = "users:admin"
+	PermTokensAdmin         Permission = "tokens:admin"
+	PermRulesRead           Permission = "rules:read"
+	PermRulesAdmin          Permission = "rules:admin"
+	PermFeedsRead           Permission = "feeds:read"
+	PermFeedsWrite          Permission = "feeds:write"
+	PermWatchRead           Permission = "watch:read"
+)
+
+// Valid returns true if p is a known permission.
+func (p Permission) Valid() bool {
+	for _, known := range adminPermissions {
+		if p == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	viewerPermissions = []Permission{
+		PermTorrentsRead,
+		PermLabelsRead,
+		PermPluginsRead,
+		PermWebhooksRead,
+		PermMetricsRead,
+		PermRulesRead,
+		PermWatchRead,
+	}
+	operatorPermissions = append([]Permission{
+		PermTorrentsAdd,
+		PermTorrentsControl,
+		PermTorrentsDelete,
+		PermTorrentsMove,
+		PermLabelsWrite,
+	}, viewerPermissions...)
+	adminPermissions = append([]Permission{
+		PermTorrentsDeleteFiles,
+		PermPluginsWrite,
+		PermUsersAdmin,
+		PermTokensAdmin,
+		PermRulesAdmin,
+		// Feeds make Storm fetch any URL, including from the network it runs in, so only admins can manage them.
+		// Feed URLs and the items added from them often contain tracker passkeys, so only admins can see them.
+		PermFeedsRead,
+		PermFeedsWrite,
+	}, operatorPermissions...)
+
+	rolePermissions = map[Role][]Permission{
+		RoleViewer:   viewerPermissions,
+		RoleOperator: operatorPermissions,
+		RoleAdmin:    adminPermissions,
+	}
+)
+
+// Principal is the authenticated identity making a request.
+type Principal struct {
+	Name string
+	Role Role
+	// Scopes limits an API token to these permissions instead of the permissions of a role
+	Scopes []Permission
+	// TokenID is the ID of the API token the principal authenticated with, if any
+	TokenID string `json:"-"`
+}
+
+// Permissions returns the permissions granted to the principal.
+func (p *Principal) Permissions() []Permission {
+	if p.Scopes != nil {
+		return p.Scopes
+	}
+
+	return rolePermissions[p.Role]
+}
+
+// Can returns true if the principal has the given permission.
+func (p *Principal) Can(permission Permission) bool {
+	for _, granted := range p.Permissions() {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+type principalContextKey struct{}
+
+func withPrincipal(r *http.Request, p *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+}
+
+// PrincipalFromRequest gets the authenticated principal of the request, if any.
+func PrincipalFromRequest(r *http.Request) (*Principal, bool) {
+	p, ok := r.Context().Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+// authorize checks that the principal making the request has the given permission.
+func authorize(r *http.Request, permission Permission) error {
+	p, ok := PrincipalFromRequest(r)
+	if !ok {
+		return &Error{Code: http.StatusUnauthorized, Message: "No authentication provided in request"}
+	}
+
+	if !p.Can(permission) {
+		return &Error{Code: http.StatusForbidden, Message: fmt.Sprintf("Permission %s is required", permission)}
+	}
+
+	return nil
+}
+
+// authorize wraps next so that it is only called if the principal making the request has the given permission.
+func (api *Api) authorize(permission Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		err := authorize(r, permission)
+		if err != nil {
+			SendError(rw, err)
+			return
+		}
+
+		next(rw, r)
+	}
+}
+
+// authEnabled returns true if requests to the API must be authenticated.
+func (api *Api) authEnabled() bool {
+	return api.apiKey != "" || api.users != nil || api.tokens != nil || api.forwardAuth.Enabled()
+}
+
+// authenticate returns the principal for a username and password.
+// An empty username authenticates using the API key.
+func (api *Api) authenticate(username, password string) (*Principal, error) {
+	if username == "" {
+		if api.apiKey == "" || subtle.ConstantTimeCompare([]byte(api.apiKey), []byte(password)) != 1 {
+			return nil, &Error{Code: http.StatusUnauthorized, Message: "Incorrect API key"}
+		}
+
+		return &Principal{Role: RoleAdmin}, nil
+	}
+
+	if api.users == nil {
+		return nil, &Error{Code: http.StatusUnauthorized, Message: "User accounts are not enabled"}
+	}
+
+	user, ok := api.users.Authenticate(username, password)
+	if !ok {
+		return nil, &Error{Code: http.StatusUnauthorized, Message: "Incorrect username or password"}
+	}
+
+	return &Principal{Name: user.Name, Role: user.Role}, nil
+}
+
+// authenticateClientCert returns the principal of the user account named by the common name of a verified TLS client certificate.
+// The boolean result is false if there is no verified certificate or no user account of that name.
+func (api *Api) authenticateClientCert(r *http.Request) (*Principal, bool) {
+	// VerifiedChains is only set if the server was configured with client CAs and the certificate was signed by one of them
+	if api.users == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+
+	name := r.TLS.PeerCertificates[0].Subject.CommonName
+	if name == "" {
+		return nil, false
+	}
+
+	user, ok := api.users.Get(name)
+	if !ok {
+		return nil, false
+	}
+
+	return &Principal{Name: user.Name, Role: user.Role}, true
+}
+
+// principalFromRequest authenticates the request.
+// It looks for the credentials using these methods in the following order:
+//   - The user name set by a trusted reverse proxy in the forward auth header
+//   - A verified TLS client certificate with the common name of a user account
+//   - An API token in a Bearer auth header
+//   - The username and password components of a Basic auth header, an empty username refers to the API key
+//   - The session token in the cookie ApiAuthCookieName, created by logging in
+func (api *Api) principalFromRequest(r *http.Request) (*Principal, error) {
+	principal, ok, err := api.authenticateForwarded(r)
+	if ok {
+		return principal, err
+	}
+
+	principal, ok = api.authenticateClientCert(r)
+	if ok {
+		return principal, nil
+	}
+
+	// Tokens are too long to guess so they are not subject to lockout,
+	// and using one must not reset the failed password attempts of the client
+	token, ok := bearerToken(r)
+	if ok {
+		principal, err := api.authenticateToken(token)
+		if err != nil {
+			api.log.Warn("Authentication failed", zap.String("Client", api.clientKey(r)), zap.String("Method", "token"))
+		}
+		return principal, err
+	}
+
+	username, password, ok := r.BasicAuth()
+	if ok {
+		if locked := api.lockedOut(r); locked != nil {
+			return nil, locked
+		}
+
+		principal, err := api.authenticate(username, password)
+		api.recordAuthentication(r, username, err)
+		return principal, err
+	}
+
+	cookie, err := r.Cookie(ApiAuthCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, &Error{
+			Code:    http.StatusUnauthorized,
+			Message: "No authentication provided in request",
+		}
+	}
+
+	session, ok := api.sessions.Get(cookie.Value)
+	if ok {
+		principal, ok := api.sessionPrincipal(session)
+		if ok {
+			return principal, nil
+		}
+	}
+
+	return nil, &Error{Code: http.StatusUnauthorized, Message: "Session has expired or is not valid"}
+}
+
+// httpMiddlewareAuthenticate authenticates the request and attaches the Principal to the request context.
+// If authentication is disabled then every request is made as an anonymous admin.
+func (api *Api) httpMiddlewareAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !api.authEnabled() {
+			next.ServeHTTP(rw, withPrincipal(r, &Principal{Role: RoleAdmin}))
+			return
+		}
+
+		principal, err := api.principalFromRequest(r)
+		if err != nil {
+			sendAuthError(rw, err)
+			return
+		}
+
+		next.ServeHTTP(rw, withPrincipal(r, principal))
+	})
+}
+
+type PrincipalResponse struct {
+	Name        string
+	Role        Role
+	Permissions []Permission
+}
+
+// httpWhoAmI gets the authenticated principal making the request
+func (api *Api) httpWhoAmI(r *http.Request) (interface{}, error) {
+	p, ok := PrincipalFromRequest(r)
+	if !ok {
+		return nil, &Error{Code: http.StatusUnauthorized, Message: "No authentication provided in request"}
+	}
+
+	return PrincipalResponse{
+		Name:        p.Name,
+		Role:        p.Role,
+		Permissions: p.Permissions(),
+	}, nil
+}
@@ -0,0 +1,185 @@
+package storm
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// bencodeMaxDepth is the deepest that lists and dictionaries can be nested.
+const bencodeMaxDepth = 256
+
+// bencodeDecoder decodes bencoded values.
+// Strings are decoded as []byte, integers as int64, lists as []interface{} and dictionaries as map[string]interface{}.
+type bencodeDecoder struct {
+	b   []byte
+	pos int
+}
+
+// decodeBencode decodes a single bencoded value that must make up all of b.
+func decodeBencode(b []byte) (interface{}, error) {
+	d := &bencodeDecoder{b: b}
+
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(d.b) {
+		return nil, fmt.Errorf("bencode: unexpected data after value at offset %d", d.pos)
+	}
+
+	return v, nil
+}
+
+// bencodeDictRaw returns the raw bencoded value of each key in the dictionary b.
+func bencodeDictRaw(b []byte) (map[string][]byte, error) {
+	d := &bencodeDecoder{b: b}
+
+	if d.pos >= len(d.b) || d.b[d.pos] != 'd' {
+		return nil, fmt.Errorf("bencode: expected a dictionary")
+	}
+
+	d.pos++
+
+	raw := make(map[string][]byte)
+	for {
+		if d.pos >= len(d.b) {
+			return nil, d.eof()
+		}
+		if d.b[d.pos] == 'e' {
+			d.pos++
+			break
+		}
+
+		key, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		start := d.pos
+
+		_, err = d.value(1)
+		if err != nil {
+			return nil, err
+		}
+
+		raw[string(key)] = d.b[start:d.pos]
+	}
+
+	if d.pos != len(d.b) {
+		return nil, fmt.Errorf("bencode: unexpected data after value at offset %d", d.pos)
+	}
+
+	return raw, nil
+}
+
+func (d *bencodeDecoder) eof() error {
+	return fmt.Errorf("bencode: unexpected end of data")
+}
+
+func (d *bencodeDecoder) value(depth int) (interface{}, error) {
+	if d.pos >= len(d.b) {
+		return nil, d.eof()
+	}
+
+	if depth > bencodeMaxDepth {
+		return nil, fmt.Errorf("bencode: values are nested too deeply")
+	}
+
+	switch c := d.b[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c >= '0' && c <= '9':
+		return d.string()
+	case c == 'l':
+		d.pos++
+
+		list := make([]interface{}, 0)
+		for {
+			if d.pos >= len(d.b) {
+				return nil, d.eof()
+			}
+			if d.b[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, v)
+		}
+	case c == 'd':
+		d.pos++
+
+		dict := make(map[string]interface{})
+		for {
+			if d.pos >= len(d.b) {
+				return nil, d.eof()
+			}
+			if d.b[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+
+			key, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			dict[string(key)] = v
+		}
+	default:
+		return nil, fmt.Errorf("bencode: unexpected %q at offset %d", c, d.pos)
+	}
+}
+
+func (d *bencodeDecoder) integer() (int64, error) {
+	start := d.pos + 1
+
+	end := bytes.IndexByte(d.b[start:], 'e')
+	if end < 0 {
+		return 0, d.eof()
+	}
+
+	end += start
+
+	n, err := strconv.ParseInt(string(d.b[start:end]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bencode: invalid integer at offset %d", d.pos)
+	}
+
+	d.pos = end + 1
+
+	return n, nil
+}
+
+func (d *bencodeDecoder) string() ([]byte, error) {
+	colon := bytes.IndexByte(d.b[d.pos:], ':')
+	if colon < 0 {
+		return nil, d.eof()
+	}
+
+	colon += d.pos
+
+	n, err := strconv.Atoi(string(d.b[d.pos:colon]))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bencode: invalid string length at offset %d", d.pos)
+	}
+
+	if n > len(d.b)-colon-1 {
+		return nil, d.eof()
+	}
+
+	d.pos = colon + 1 + n
+
+	return d.b[colon+1 : d.pos], nil
+}
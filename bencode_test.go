@@ -0,0 +1,150 @@
+package storm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// bencode encodes strings, []byte, integers, lists and dictionaries for tests.
+func bencode(v interface{}) []byte {
+	var b strings.Builder
+
+	var encode func(v interface{})
+	encode = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			fmt.Fprintf(&b, "%d:%s", len(v), v)
+		case []byte:
+			fmt.Fprintf(&b, "%d:%s", len(v), v)
+		case int:
+			fmt.Fprintf(&b, "i%de", v)
+		case int64:
+			fmt.Fprintf(&b, "i%de", v)
+		case []interface{}:
+			b.WriteByte('l')
+			for _, item := range v {
+				encode(item)
+			}
+			b.WriteByte('e')
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+
+			sort.Strings(keys)
+
+			b.WriteByte('d')
+			for _, key := range keys {
+				encode(key)
+				encode(v[key])
+			}
+			b.WriteByte('e')
+		default:
+			panic(fmt.Sprintf("cannot bencode %T", v))
+		}
+	}
+
+	encode(v)
+
+	return []byte(b.String())
+}
+
+func TestDecodeBencode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"i42e", int64(42)},
+		{"i-7e", int64(-7)},
+		{"i0e", int64(0)},
+		{"4:spam", []byte("spam")},
+		{"0:", []byte{}},
+		{"le", []interface{}{}},
+		{"l4:spami1ee", []interface{}{[]byte("spam"), int64(1)}},
+		{"de", map[string]interface{}{}},
+		{"d3:cow3:moo4:spaml1:a1:bee", map[string]interface{}{
+			"cow":  []byte("moo"),
+			"spam": []interface{}{[]byte("a"), []byte("b")},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := decodeBencode([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("decodeBencode() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeBencode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBencodeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"unterminated integer", "i42"},
+		{"invalid integer", "iabce"},
+		{"empty integer", "ie"},
+		{"truncated string", "10:spam"},
+		{"string without length", ":spam"},
+		{"negative string length", "-1:a"},
+		{"unterminated list", "l4:spam"},
+		{"unterminated dictionary", "d3:cow3:moo"},
+		{"dictionary without value", "d3:cowe"},
+		{"dictionary with integer key", "di1e3:mooe"},
+		{"unknown type", "x"},
+		{"trailing data", "i1ei2e"},
+		{"too deeply nested", strings.Repeat("l", bencodeMaxDepth+2) + strings.Repeat("e", bencodeMaxDepth+2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeBencode([]byte(tt.input))
+			if err == nil {
+				t.Errorf("decodeBencode(%q) expected an error", tt.input)
+			}
+		})
+	}
+}
+
+func TestDecodeBencodeMaxDepth(t *testing.T) {
+	input := strings.Repeat("l", bencodeMaxDepth+1) + strings.Repeat("e", bencodeMaxDepth+1)
+
+	_, err := decodeBencode([]byte(input))
+	if err != nil {
+		t.Errorf("decodeBencode() of lists nested %d deep error = %v", bencodeMaxDepth, err)
+	}
+}
+
+func TestBencodeDictRaw(t *testing.T) {
+	raw, err := bencodeDictRaw([]byte("d4:infod4:name4:teste8:announce3:urle"))
+	if err != nil {
+		t.Fatalf("bencodeDictRaw() error = %v", err)
+	}
+
+	want := map[string][]byte{
+		"info":     []byte("d4:name4:teste"),
+		"announce": []byte("3:url"),
+	}
+
+	if !reflect.DeepEqual(raw, want) {
+		t.Errorf("bencodeDictRaw() = %q, want %q", raw, want)
+	}
+
+	for _, input := range []string{"", "le", "d4:info", "d4:infoi1ee4:more", "d4:info" + strings.Repeat("l", bencodeMaxDepth+1)} {
+		_, err := bencodeDictRaw([]byte(input))
+		if err == nil {
+			t.Errorf("bencodeDictRaw(%q) expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often the certificate files are checked for changes.
+const certReloadInterval = time.Second * 30
+
+// NewCertReloader loads the certificate and key from disk.
+func NewCertReloader(log *zap.Logger, certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		log:      log,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CertReloader serves a TLS certificate and reloads it when the certificate or key file changes on disk,
+// such as when the certificate is renewed by certbot.
+type CertReloader struct {
+	log      *zap.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// lastModified returns the most recent modification time of the certificate and key files.
+func (r *CertReloader) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
+
+// reload loads the certificate from disk. The caller must hold the lock, or be the constructor.
+func (r *CertReloader) reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+// If the certificate cannot be reloaded then the previous certificate continues to be used.
+func (r *CertReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < certReloadInterval {
+		return r.cert, nil
+	}
+
+	r.checked = time.Now()
+
+	modTime, err := r.lastModified()
+	if err != nil {
+		r.log.Error("Failed to check TLS certificate for changes", zap.Error(err))
+		return r.cert, nil
+	}
+
+	if modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	err = r.reload()
+	if err != nil {
+		r.log.Error("Failed to reload TLS certificate", zap.Error(err))
+		return r.cert, nil
+	}
+
+	r.log.Info("Reloaded TLS certificate")
+	return r.cert, nil
+}
+
+// TLSConfig returns the TLS configuration for the server.
+// If TLSClientCA is set then clients may present a certificate signed by a CA in that file,
+// which is required if TLSClientCertRequired is set.
+func (options *ServerOptions) TLSConfig(log *zap.Logger) (*tls.Config, error) {
+	reloader, err := NewCertReloader(log, options.TLSCert, options.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if options.TLSClientCA != "" {
+		pem, err := os.ReadFile(options.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA %s", options.TLSClientCA)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if options.TLSClientCertRequired {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, nil
+}
+
+// redirectToHTTPS returns a handler that redirects every request to the same URL on the HTTPS listener at listen.
+func redirectToHTTPS(listen string) http.Handler {
+	_, port, _ := net.SplitHostPort(listen)
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := fmt.Sprintf("https://%s%s", host, r.URL.RequestURI())
+		http.Redirect(rw, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,114 @@
+package storm
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// NewDaemons creates an empty registry of Deluge daemons.
+func NewDaemons() *Daemons {
+	return &Daemons{
+		pools: make(map[string]*ConnectionPool),
+	}
+}
+
+// Daemons is a registry of named Deluge daemons, each with its own connection pool.
+// The first daemon added to the registry is the default daemon.
+type Daemons struct {
+	mu    sync.RWMutex
+	names []string
+	pools map[string]*ConnectionPool
+}
+
+// Add adds a named daemon to the registry.
+func (d *Daemons) Add(name string, pool *ConnectionPool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("a Deluge daemon must have a name")
+	}
+
+	if _, ok := d.pools[name]; ok {
+		return fmt.Errorf("a Deluge daemon named %q already exists", name)
+	}
+
+	d.names = append(d.names, name)
+	d.pools[name] = pool
+
+	return nil
+}
+
+// Default returns the name of the default daemon.
+func (d *Daemons) Default() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if len(d.names) == 0 {
+		return ""
+	}
+
+	return d.names[0]
+}
+
+// Names returns the names of all daemons in the registry in the order they were added.
+func (d *Daemons) Names() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := make([]string, len(d.names))
+	copy(names, d.names)
+
+	return names
+}
+
+// Get gets the connection pool for the named daemon.
+// If name is empty then the default daemon is used.
+func (d *Daemons) Get(name string) (*ConnectionPool, error) {
+	if name == "" {
+		name = d.Default()
+	}
+
+	d.mu.RLock()
+	pool, ok := d.pools[name]
+	d.mu.RUnlock()
+
+	if !ok {
+		return nil, &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Unknown Deluge daemon %q", name)}
+	}
+
+	return pool, nil
+}
+
+// Close closes the connection pools of all daemons within the registry.
+func (d *Daemons) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, name := range d.names {
+		d.pools[name].Close()
+	}
+}
+
+type DaemonResponse struct {
+	Name    string
+	Default bool
+}
+
+func (api *Api) httpDaemons(_ *http.Request) (interface{}, error) {
+	var (
+		names         = api.daemons.Names()
+		response      = make([]DaemonResponse, 0, len(names))
+		defaultDaemon = api.daemons.Default()
+	)
+
+	for _, name := range names {
+		response = append(response, DaemonResponse{
+			Name:    name,
+			Default: name == defaultDaemon,
+		})
+	}
+
+	return response, nil
+}
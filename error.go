@@ -2,6 +2,7 @@ package storm
 
 import (
 	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
 	"net/http"
 )
 
@@ -24,6 +25,20 @@ func (e RPCError) Error() string {
 	return fmt.Sprintf("%s: %s", e.ExceptionType, e.ExceptionMessage)
 }
 
+// rpcError converts a Deluge RPC error into an RPCError.
+// Any other error is returned as-is.
+func rpcError(err error) error {
+	switch t := err.(type) {
+	case deluge.RPCError:
+		return RPCError{
+			ExceptionType:    t.ExceptionType,
+			ExceptionMessage: t.ExceptionMessage,
+		}
+	}
+
+	return err
+}
+
 var _ HTTPError = (*Error)(nil)
 
 // Hint wraps an input error to hint the HTTP status code.
@@ -0,0 +1,821 @@
+package storm
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFeedInterval is how often a feed is polled if its Interval is not set.
+	DefaultFeedInterval = time.Minute * 15
+	// MinFeedInterval is the shortest interval that a feed can be polled at.
+	MinFeedInterval = time.Minute
+
+	// feedCheckInterval is how often feeds are checked to see if they are due to be polled.
+	feedCheckInterval = time.Second * 30
+	// feedFetchTimeout is the timeout of fetching a feed.
+	feedFetchTimeout = time.Second * 30
+	// feedMaxSize is the largest feed document that will be read.
+	feedMaxSize = 16 << 20
+	// feedSeenSize is the number of items remembered for each feed so that they are only added once.
+	feedSeenSize = 1000
+	// feedHistorySize is the number of matched items kept in the history.
+	feedHistorySize = 500
+)
+
+// FeedItem is an item of an RSS or Atom feed.
+type FeedItem struct {
+	GUID  string
+	Title string
+	// URI is the magnet URI or URL of the torrent file
+	URI string
+}
+
+type rssEnclosure struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title string `xml:"title"`
+	// Links also captures any atom:link elements within the item
+	Links      []string       `xml:"link"`
+	GUID       string         `xml:"guid"`
+	MagnetURI  string         `xml:"magnetURI"`
+	Enclosures []rssEnclosure `xml:"enclosure"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomEntry struct {
+	Title string     `xml:"title"`
+	ID    string     `xml:"id"`
+	Links []atomLink `xml:"link"`
+}
+
+// feedDocument is either an RSS 2.0 or Atom document.
+type feedDocument struct {
+	Items   []rssItem   `xml:"channel>item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+const mimeTypeBitTorrent = "application/x-bittorrent"
+
+func (item *rssItem) feedItem() FeedItem {
+	var uri string
+	switch {
+	case item.MagnetURI != "":
+		uri = item.MagnetURI
+	case len(item.Enclosures) > 0:
+		uri = item.Enclosures[0].URL
+		for _, enclosure := range item.Enclosures {
+			if enclosure.Type == mimeTypeBitTorrent {
+				uri = enclosure.URL
+				break
+			}
+		}
+	default:
+		for _, link := range item.Links {
+			if link = strings.TrimSpace(link); link != "" {
+				uri = link
+				break
+			}
+		}
+	}
+
+	return FeedItem{
+		GUID:  strings.TrimSpace(item.GUID),
+		Title: strings.TrimSpace(item.Title),
+		URI:   strings.TrimSpace(uri),
+	}
+}
+
+func (entry *atomEntry) feedItem() FeedItem {
+	var uri string
+	for _, link := range entry.Links {
+		if link.Type == mimeTypeBitTorrent || link.Rel == "enclosure" {
+			uri = link.Href
+			break
+		}
+		if uri == "" && (link.Rel == "" || link.Rel == "alternate") {
+			uri = link.Href
+		}
+	}
+
+	return FeedItem{
+		GUID:  strings.TrimSpace(entry.ID),
+		Title: strings.TrimSpace(entry.Title),
+		URI:   strings.TrimSpace(uri),
+	}
+}
+
+// parseFeed reads the items of an RSS or Atom feed.
+// Items without a link to a torrent are ignored.
+// If an item has no GUID then its URI is used instead.
+func parseFeed(r io.Reader) ([]FeedItem, error) {
+	var doc feedDocument
+
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	items := make([]FeedItem, 0, len(doc.Items)+len(doc.Entries))
+	for i := range doc.Items {
+		items = append(items, doc.Items[i].feedItem())
+	}
+	for i := range doc.Entries {
+		items = append(items, doc.Entries[i].feedItem())
+	}
+
+	valid := items[:0]
+	for _, item := range items {
+		if item.URI == "" {
+			continue
+		}
+		if item.GUID == "" {
+			item.GUID = item.URI
+		}
+
+		valid = append(valid, item)
+	}
+
+	return valid, nil
+}
+
+// Feed is an RSS or Atom feed that matching torrents are added from.
+type Feed struct {
+	ID   string
+	Name string
+	URL  string
+	// Interval is how often the feed is polled, such as 15m
+	Interval string
+	// Include is a regular expression that item titles must match to be added
+	Include string
+	// Exclude is a regular expression that item titles must not match to be added
+	Exclude string
+	// Daemon is the daemon that torrents are added to, or the default daemon if empty
+	Daemon string
+	// Label is applied to torrents after they are added if not empty
+	Label   string
+	Options deluge.Options
+	Enabled bool
+}
+
+type compiledFeed struct {
+	Feed
+	interval time.Duration
+	include  *regexp.Regexp
+	exclude  *regexp.Regexp
+}
+
+// match returns true if an item with title should be added.
+func (f *compiledFeed) match(title string) bool {
+	if f.include != nil && !f.include.MatchString(title) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(title) {
+		return false
+	}
+
+	return true
+}
+
+func compileFeed(feed Feed) (*compiledFeed, error) {
+	if feed.Name == "" {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Feed Name must not be empty"}
+	}
+
+	u, err := url.Parse(feed.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Feed URL must be an http or https URL"}
+	}
+
+	compiled := &compiledFeed{Feed: feed, interval: DefaultFeedInterval}
+
+	if feed.Interval != "" {
+		compiled.interval, err = time.ParseDuration(feed.Interval)
+		if err != nil {
+			return nil, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Feed Interval is not valid: %s", err)}
+		}
+		if compiled.interval < MinFeedInterval {
+			return nil, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Feed Interval must be at least %s", MinFeedInterval)}
+		}
+	}
+
+	if feed.Include != "" {
+		compiled.include, err = regexp.Compile(feed.Include)
+		if err != nil {
+			return nil, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Feed Include is not a valid regular expression: %s", err)}
+		}
+	}
+
+	if feed.Exclude != "" {
+		compiled.exclude, err = regexp.Compile(feed.Exclude)
+		if err != nil {
+			return nil, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Feed Exclude is not a valid regular expression: %s", err)}
+		}
+	}
+
+	return compiled, nil
+}
+
+// FeedMatch is an item of a feed that matched its filters and was added, or failed to be added.
+type FeedMatch struct {
+	Time     time.Time
+	Feed     string
+	FeedName string
+	GUID     string
+	Title    string
+	URI      string
+	Daemon   string
+	Torrent  string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+type feedsFile struct {
+	Feeds []Feed
+	// Seen is the GUIDs of the items that have been added from each feed, oldest first
+	Seen    map[string][]string
+	History []*FeedMatch
+}
+
+// OpenFeedStore opens the file-backed feed store at path.
+// The file is created when the first feed is added.
+func OpenFeedStore(path string) (*FeedStore, error) {
+	var f feedsFile
+
+	err := loadJSON(path, &f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feeds from %s: %w", path, err)
+	}
+
+	store := &FeedStore{
+		path:    path,
+		seen:    f.Seen,
+		history: f.History,
+	}
+
+	if store.seen == nil {
+		store.seen = make(map[string][]string)
+	}
+
+	for _, feed := range f.Feeds {
+		compiled, err := compileFeed(feed)
+		if err != nil {
+			return nil, fmt.Errorf("feed %q in %s: %w", feed.Name, path, err)
+		}
+
+		store.feeds = append(store.feeds, compiled)
+	}
+
+	return store, nil
+}
+
+// FeedStore is a file-backed list of feeds along with the history of items added from them.
+type FeedStore struct {
+	path string
+
+	mu      sync.RWMutex
+	feeds   []*compiledFeed
+	seen    map[string][]string
+	history []*FeedMatch
+}
+
+// save persists the store. The caller must hold the write lock.
+func (s *FeedStore) save() error {
+	f := feedsFile{
+		Feeds:   make([]Feed, 0, len(s.feeds)),
+		Seen:    s.seen,
+		History: s.history,
+	}
+
+	for _, feed := range s.feeds {
+		f.Feeds = append(f.Feeds, feed.Feed)
+	}
+
+	return saveJSON(s.path, &f)
+}
+
+func (s *FeedStore) compiled() []*compiledFeed {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	feeds := make([]*compiledFeed, len(s.feeds))
+	copy(feeds, s.feeds)
+
+	return feeds
+}
+
+func (s *FeedStore) get(id string) (*compiledFeed, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, feed := range s.feeds {
+		if feed.ID == id {
+			return feed, nil
+		}
+	}
+
+	return nil, &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Feed %q does not exist", id)}
+}
+
+// List returns all feeds.
+func (s *FeedStore) List() []Feed {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	feeds := make([]Feed, 0, len(s.feeds))
+	for _, feed := range s.feeds {
+		feeds = append(feeds, feed.Feed)
+	}
+
+	return feeds
+}
+
+// Create adds a new feed.
+func (s *FeedStore) Create(feed Feed) (Feed, error) {
+	feed.ID = randomID()[:16]
+
+	compiled, err := compileFeed(feed)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.feeds = append(s.feeds, compiled)
+
+	err = s.save()
+	if err != nil {
+		s.feeds = s.feeds[:len(s.feeds)-1]
+		return Feed{}, err
+	}
+
+	return feed, nil
+}
+
+// Update replaces an existing feed. Items already added from the feed are not added again.
+func (s *FeedStore) Update(id string, feed Feed) (Feed, error) {
+	feed.ID = id
+
+	compiled, err := compileFeed(feed)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.feeds {
+		if existing.ID != id {
+			continue
+		}
+
+		s.feeds[i] = compiled
+
+		err = s.save()
+		if err != nil {
+			s.feeds[i] = existing
+			return Feed{}, err
+		}
+
+		return feed, nil
+	}
+
+	return Feed{}, &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Feed %q does not exist", id)}
+}
+
+// Delete removes a feed and forgets which of its items have been added.
+func (s *FeedStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.feeds {
+		if existing.ID != id {
+			continue
+		}
+
+		var (
+			previous = s.feeds
+			seen     = s.seen[id]
+		)
+
+		s.feeds = append(append([]*compiledFeed(nil), s.feeds[:i]...), s.feeds[i+1:]...)
+		delete(s.seen, id)
+
+		err := s.save()
+		if err != nil {
+			s.feeds = previous
+			s.seen[id] = seen
+		}
+
+		return err
+	}
+
+	return &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Feed %q does not exist", id)}
+}
+
+// Seen returns true if the item with guid has already been added from the feed.
+func (s *FeedStore) Seen(feed, guid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, seen := range s.seen[feed] {
+		if seen == guid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Record marks the item of match as added from its feed and records it in the history.
+func (s *FeedStore) Record(match *FeedMatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := append(s.seen[match.Feed], match.GUID)
+	if len(seen) > feedSeenSize {
+		seen = seen[len(seen)-feedSeenSize:]
+	}
+	s.seen[match.Feed] = seen
+
+	s.history = append(s.history, match)
+	if len(s.history) > feedHistorySize {
+		s.history = s.history[len(s.history)-feedHistorySize:]
+	}
+
+	return s.save()
+}
+
+// History returns the most recently matched items, newest first.
+// If feed is not empty then only items from that feed are returned.
+func (s *FeedStore) History(feed string) []*FeedMatch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := make([]*FeedMatch, 0, len(s.history))
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if feed != "" && s.history[i].Feed != feed {
+			continue
+		}
+
+		match := *s.history[i]
+		history = append(history, &match)
+	}
+
+	return history
+}
+
+// FeedStatus is the result of the last poll of a feed.
+type FeedStatus struct {
+	LastPolled *time.Time
+	LastError  string `json:",omitempty"`
+}
+
+type FeedResponse struct {
+	Feed
+	FeedStatus
+}
+
+// NewFeedWatcher creates a new FeedWatcher. Call Start to begin polling feeds.
+func NewFeedWatcher(log *zap.Logger, daemons *Daemons, store *FeedStore) *FeedWatcher {
+	return &FeedWatcher{
+		log:     log,
+		daemons: daemons,
+		store:   store,
+		client:  &http.Client{Timeout: feedFetchTimeout},
+		status:  make(map[string]*FeedStatus),
+	}
+}
+
+// FeedWatcher polls feeds and adds torrents from items that match their filters.
+type FeedWatcher struct {
+	log     *zap.Logger
+	daemons *Daemons
+	store   *FeedStore
+	client  *http.Client
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// run is held while a feed is being polled
+	run sync.Mutex
+
+	mu     sync.Mutex
+	status map[string]*FeedStatus
+}
+
+// Start starts polling feeds in the background.
+func (w *FeedWatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(feedCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			w.pollDue(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling feeds and waits for any poll in progress to finish.
+func (w *FeedWatcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+
+	w.cancel()
+	w.wg.Wait()
+}
+
+// Status returns the result of the last poll of a feed.
+func (w *FeedWatcher) Status(id string) FeedStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if status, ok := w.status[id]; ok {
+		return *status
+	}
+
+	return FeedStatus{}
+}
+
+// pollDue polls every enabled feed that has not been polled within its interval.
+func (w *FeedWatcher) pollDue(ctx context.Context) {
+	now := time.Now()
+
+	for _, feed := range w.store.compiled() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !feed.Enabled {
+			continue
+		}
+
+		status := w.Status(feed.ID)
+		if status.LastPolled != nil && now.Sub(*status.LastPolled) < feed.interval {
+			continue
+		}
+
+		_, _ = w.Poll(ctx, feed.ID)
+	}
+}
+
+// fetch downloads and parses the feed at u.
+func (w *FeedWatcher) fetch(ctx context.Context, u string) ([]FeedItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "storm")
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml, text/xml")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed responded with %s", resp.Status)
+	}
+
+	return parseFeed(io.LimitReader(resp.Body, feedMaxSize))
+}
+
+// Poll fetches a feed now and adds any new matching items, returning the items that matched.
+func (w *FeedWatcher) Poll(ctx context.Context, id string) ([]*FeedMatch, error) {
+	w.run.Lock()
+	defer w.run.Unlock()
+
+	feed, err := w.store.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := w.poll(ctx, feed)
+
+	var (
+		now    = time.Now().UTC()
+		status = &FeedStatus{LastPolled: &now}
+	)
+
+	if err != nil {
+		status.LastError = err.Error()
+		w.log.Error("Failed to poll feed", zap.String("Feed", feed.Name), zap.String("URL", feed.URL), zap.Error(err))
+	}
+
+	w.mu.Lock()
+	w.status[feed.ID] = status
+	w.mu.Unlock()
+
+	return matches, err
+}
+
+func (w *FeedWatcher) poll(ctx context.Context, feed *compiledFeed) ([]*FeedMatch, error) {
+	items, err := w.fetch(ctx, feed.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	daemon := feed.Daemon
+	if daemon == "" {
+		daemon = w.daemons.Default()
+	}
+
+	pool, err := w.daemons.Get(daemon)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*FeedMatch, 0)
+
+	// Feeds list the newest items first, add the oldest first
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if !feed.match(item.Title) || w.store.Seen(feed.ID, item.GUID) {
+			continue
+		}
+
+		match := &FeedMatch{
+			Time:     time.Now().UTC(),
+			Feed:     feed.ID,
+			FeedName: feed.Name,
+			GUID:     item.GUID,
+			Title:    item.Title,
+			URI:      item.URI,
+			Daemon:   daemon,
+		}
+
+		err = pool.Do(ctx, func(conn deluge.DelugeClient) error {
+			id, err := addFeedItem(conn, feed, item)
+			if err != nil {
+				return err
+			}
+
+			match.Torrent = id
+
+			if feed.Label != "" {
+				plugin, err := labelPluginClient(conn)
+				if err == nil {
+					err = plugin.SetTorrentLabel(match.Torrent, feed.Label)
+				}
+				if err != nil {
+					match.Error = fmt.Sprintf("Failed to set label: %s", rpcError(err))
+				}
+			}
+
+			return nil
+		})
+
+		// Deluge rejected the torrent, such as if it is already added, so don't try to add it again.
+		// Any other error is retried on the next poll.
+		if rpcErr, ok := err.(deluge.RPCError); ok {
+			match.Error = rpcError(rpcErr).Error()
+		} else if err != nil {
+			return matches, err
+		}
+
+		w.log.Info("Added torrent from feed",
+			zap.String("Feed", feed.Name),
+			zap.String("Title", item.Title),
+			zap.String("Torrent", match.Torrent),
+			zap.String("Error", match.Error),
+		)
+
+		err = w.store.Record(match)
+		if err != nil {
+			return matches, fmt.Errorf("failed to save feed history: %w", err)
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
+
+// addFeedItem adds the torrent of a feed item to Deluge.
+func addFeedItem(conn deluge.DelugeClient, feed *compiledFeed, item FeedItem) (string, error) {
+	var (
+		options = feed.Options
+		id      string
+		err     error
+	)
+
+	if strings.HasPrefix(item.URI, "magnet:") {
+		id, err = conn.AddTorrentMagnet(item.URI, &options)
+	} else {
+		id, err = conn.AddTorrentURL(item.URI, &options)
+	}
+
+	if err == nil && id == "" {
+		err = deluge.RPCError{ExceptionType: "AddTorrentError", ExceptionMessage: "Torrent could not be read"}
+	}
+
+	return id, err
+}
+
+func (api *Api) validateFeed(r *http.Request) (Feed, error) {
+	var feed Feed
+
+	err := Read(r, &feed)
+	if err != nil {
+		return feed, err
+	}
+
+	_, err = api.daemons.Get(feed.Daemon)
+	if err != nil {
+		return feed, Hint(http.StatusBadRequest, err)
+	}
+
+	return feed, nil
+}
+
+// httpFeeds lists all feeds along with the result of their last poll
+func (api *Api) httpFeeds(_ *http.Request) (interface{}, error) {
+	feeds := api.feeds.store.List()
+
+	response := make([]FeedResponse, 0, len(feeds))
+	for _, feed := range feeds {
+		response = append(response, FeedResponse{
+			Feed:       feed,
+			FeedStatus: api.feeds.Status(feed.ID),
+		})
+	}
+
+	return response, nil
+}
+
+// httpCreateFeed creates a new feed
+func (api *Api) httpCreateFeed(r *http.Request) (interface{}, error) {
+	feed, err := api.validateFeed(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.feeds.store.Create(feed)
+}
+
+// httpUpdateFeed replaces an existing feed
+func (api *Api) httpUpdateFeed(r *http.Request) (interface{}, error) {
+	feed, err := api.validateFeed(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.feeds.store.Update(mux.Vars(r)["id"], feed)
+}
+
+// httpDeleteFeed deletes a feed
+func (api *Api) httpDeleteFeed(r *http.Request) (interface{}, error) {
+	return nil, api.feeds.store.Delete(mux.Vars(r)["id"])
+}
+
+// httpPollFeed polls a feed now and returns the items that were added
+func (api *Api) httpPollFeed(r *http.Request) (interface{}, error) {
+	matches, err := api.feeds.Poll(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return nil, Hint(http.StatusBadGateway, err)
+	}
+
+	return matches, nil
+}
+
+// httpFeedHistory gets the most recent items added from feeds.
+//
+//	?feed=	Only return items from this feed
+func (api *Api) httpFeedHistory(r *http.Request) (interface{}, error) {
+	return api.feeds.store.History(r.URL.Query().Get("feed")), nil
+}
@@ -0,0 +1,311 @@
+package storm
+
+import (
+	"context"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []FeedItem
+	}{
+		{
+			name: "rss magnet uri",
+			doc: `<rss><channel><item>
+				<title>Magnet</title><guid>1</guid><link>https://example.com/1</link>
+				<torznab:magnetURI xmlns:torznab="http://torznab.com/schemas/2015/feed">magnet:?xt=urn:btih:1</torznab:magnetURI>
+			</item></channel></rss>`,
+			want: []FeedItem{{GUID: "1", Title: "Magnet", URI: "magnet:?xt=urn:btih:1"}},
+		},
+		{
+			name: "rss enclosure preferred over link",
+			doc: `<rss><channel><item>
+				<title>Enclosure</title><guid>2</guid><link>https://example.com/details/2</link>
+				<enclosure url="https://example.com/2.torrent" type="application/x-bittorrent"/>
+			</item></channel></rss>`,
+			want: []FeedItem{{GUID: "2", Title: "Enclosure", URI: "https://example.com/2.torrent"}},
+		},
+		{
+			name: "rss bittorrent enclosure preferred over other enclosures",
+			doc: `<rss><channel><item>
+				<title>Enclosures</title><guid>3</guid>
+				<enclosure url="https://example.com/3.jpg" type="image/jpeg"/>
+				<enclosure url="https://example.com/3.torrent" type="application/x-bittorrent"/>
+			</item></channel></rss>`,
+			want: []FeedItem{{GUID: "3", Title: "Enclosures", URI: "https://example.com/3.torrent"}},
+		},
+		{
+			name: "rss link without guid",
+			doc: `<rss><channel><item>
+				<title> Link </title><link> https://example.com/4.torrent </link>
+			</item></channel></rss>`,
+			want: []FeedItem{{GUID: "https://example.com/4.torrent", Title: "Link", URI: "https://example.com/4.torrent"}},
+		},
+		{
+			name: "rss item without torrent ignored",
+			doc:  `<rss><channel><item><title>Nothing</title><guid>5</guid></item></channel></rss>`,
+			want: []FeedItem{},
+		},
+		{
+			name: "atom enclosure preferred over alternate",
+			doc: `<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+				<title>Atom</title><id>6</id>
+				<link rel="alternate" href="https://example.com/details/6"/>
+				<link rel="enclosure" href="https://example.com/6.torrent"/>
+			</entry></feed>`,
+			want: []FeedItem{{GUID: "6", Title: "Atom", URI: "https://example.com/6.torrent"}},
+		},
+		{
+			name: "atom bittorrent type",
+			doc: `<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+				<title>Atom Type</title><id>7</id>
+				<link href="https://example.com/details/7"/>
+				<link rel="related" type="application/x-bittorrent" href="https://example.com/7.torrent"/>
+			</entry></feed>`,
+			want: []FeedItem{{GUID: "7", Title: "Atom Type", URI: "https://example.com/7.torrent"}},
+		},
+		{
+			name: "atom alternate link",
+			doc: `<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+				<title>Atom Alternate</title><id>8</id>
+				<link rel="self" href="https://example.com/self"/>
+				<link rel="alternate" href="https://example.com/8.torrent"/>
+			</entry></feed>`,
+			want: []FeedItem{{GUID: "8", Title: "Atom Alternate", URI: "https://example.com/8.torrent"}},
+		},
+		{
+			name: "atom entry without torrent ignored",
+			doc: `<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+				<title>Atom Self</title><id>9</id><link rel="self" href="https://example.com/self"/>
+			</entry></feed>`,
+			want: []FeedItem{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFeed(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatalf("parseFeed() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFeed() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedMalformed(t *testing.T) {
+	_, err := parseFeed(strings.NewReader(`<rss><channel><item><title>`))
+	if err == nil {
+		t.Fatal("parseFeed() expected an error")
+	}
+}
+
+func TestCompiledFeedMatch(t *testing.T) {
+	feed, err := compileFeed(Feed{
+		Name:    "Test",
+		URL:     "https://example.com/rss",
+		Include: `(?i)show\.s01`,
+		Exclude: `(?i)hdr`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		title string
+		want  bool
+	}{
+		{"Show.S01E01.1080p", true},
+		{"show.s01e02.720p", true},
+		{"Show.S01E03.2160p.HDR", false},
+		{"Other.S01E01.1080p", false},
+	}
+
+	for _, tt := range tests {
+		if got := feed.match(tt.title); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.title, got, tt.want)
+		}
+	}
+}
+
+// feedTestClient is a Deluge client that records the torrents added to it.
+// Any torrent with reject in its URI is rejected by the daemon.
+type feedTestClient struct {
+	deluge.DelugeClient
+
+	mu    sync.Mutex
+	added []string
+}
+
+func (c *feedTestClient) Connect() error { return nil }
+
+func (c *feedTestClient) Close() error { return nil }
+
+func (c *feedTestClient) add(uri string) (string, error) {
+	if strings.Contains(uri, "reject") {
+		return "", deluge.RPCError{ExceptionType: "AddTorrentError", ExceptionMessage: "Torrent already in session"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.added = append(c.added, uri)
+	return fmt.Sprintf("%040d", len(c.added)), nil
+}
+
+func (c *feedTestClient) AddTorrentMagnet(uri string, _ *deluge.Options) (string, error) {
+	return c.add(uri)
+}
+
+func (c *feedTestClient) AddTorrentURL(uri string, _ *deluge.Options) (string, error) {
+	return c.add(uri)
+}
+
+func (c *feedTestClient) Added() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.added...)
+}
+
+func TestFeedWatcherPoll(t *testing.T) {
+	var doc = `<rss><channel>
+		<item><title>Show.S01E03</title><guid>3</guid><link>https://example.com/reject/3.torrent</link></item>
+		<item><title>Other.S01E01</title><guid>other</guid><link>https://example.com/other.torrent</link></item>
+		<item><title>Show.S01E02</title><guid>2</guid><link>https://example.com/2.torrent</link></item>
+		<item><title>Show.S01E01</title><guid>1</guid><torznab:magnetURI xmlns:torznab="http://torznab.com/schemas/2015/feed">magnet:?xt=urn:btih:1</torznab:magnetURI></item>
+	</channel></rss>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = rw.Write([]byte(doc))
+	}))
+	defer server.Close()
+
+	var (
+		path   = filepath.Join(t.TempDir(), "feeds.json")
+		client = new(feedTestClient)
+		pool   = NewConnectionPool(zap.NewNop(), 1, time.Minute, func() deluge.DelugeClient { return client })
+	)
+
+	defer pool.Close()
+
+	daemons := NewDaemons()
+	if err := daemons.Add("default", pool); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFeedStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := store.Create(Feed{Name: "Show", URL: server.URL, Include: `^Show\.`, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := NewFeedWatcher(zap.NewNop(), daemons, store)
+
+	matches, err := watcher.Poll(context.Background(), feed.ID)
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+
+	// Items are added oldest first and a rejected item is recorded with its error
+	var got []string
+	for _, match := range matches {
+		got = append(got, match.GUID+":"+match.Error)
+	}
+
+	want := []string{"1:", "2:", "3:AddTorrentError: Torrent already in session"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Poll() matches = %v, want %v", got, want)
+	}
+
+	wantAdded := []string{"magnet:?xt=urn:btih:1", "https://example.com/2.torrent"}
+	if added := client.Added(); !reflect.DeepEqual(added, wantAdded) {
+		t.Errorf("added = %v, want %v", added, wantAdded)
+	}
+
+	// Polling again does not add any item a second time, including the rejected item
+	matches, err = watcher.Poll(context.Background(), feed.ID)
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("second Poll() matches = %d, want 0", len(matches))
+	}
+	if added := client.Added(); len(added) != len(wantAdded) {
+		t.Errorf("second Poll() added %v", added[len(wantAdded):])
+	}
+
+	// Items that have been added are remembered when the store is reopened
+	reopened, err := OpenFeedStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, guid := range []string{"1", "2", "3"} {
+		if !reopened.Seen(feed.ID, guid) {
+			t.Errorf("Seen(%q) = false after reopening the store", guid)
+		}
+	}
+	if reopened.Seen(feed.ID, "other") {
+		t.Error("Seen(\"other\") = true for an item that did not match the filter")
+	}
+	if history := reopened.History(feed.ID); len(history) != 3 || history[0].GUID != "3" {
+		t.Errorf("History() = %d items, want 3 newest first", len(history))
+	}
+}
+
+func TestFeedWatcherPollHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	store, err := OpenFeedStore(filepath.Join(t.TempDir(), "feeds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := store.Create(Feed{Name: "Missing", URL: server.URL, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := NewFeedWatcher(zap.NewNop(), NewDaemons(), store)
+
+	_, err = watcher.Poll(context.Background(), feed.ID)
+	if err == nil {
+		t.Fatal("Poll() expected an error")
+	}
+
+	if status := watcher.Status(feed.ID); status.LastPolled == nil || status.LastError == "" {
+		t.Errorf("Status() = %+v, want the error of the last poll", status)
+	}
+}
+
+func TestFeedPermissions(t *testing.T) {
+	// Feed URLs and history can contain tracker passkeys
+	for role, want := range map[Role]bool{RoleViewer: false, RoleOperator: false, RoleAdmin: true} {
+		p := &Principal{Name: "user", Role: role}
+		if p.Can(PermFeedsRead) != want || p.Can(PermFeedsWrite) != want {
+			t.Errorf("role %s Can() read %t, write %t, want %t", role, p.Can(PermFeedsRead), p.Can(PermFeedsWrite), want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package storm
+
+import (
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+// TorrentFilter selects torrents by their status.
+// Empty fields match any torrent.
+type TorrentFilter struct {
+	State       deluge.TorrentState
+	Label       string
+	TrackerHost string
+	// Name is a regular expression matched against the torrent name
+	Name string
+	// All must be set to select every torrent with a filter that has no other fields set
+	All bool
+}
+
+// validate checks that the filter selects torrents deliberately, so that an empty filter cannot match every torrent by mistake.
+func (f *TorrentFilter) validate() error {
+	if f.All || f.State != deluge.StateUnspecified || f.Label != "" || f.TrackerHost != "" || f.Name != "" {
+		return nil
+	}
+
+	return &Error{Code: http.StatusBadRequest, Message: "Filter must have at least one condition, or set All to select every torrent"}
+}
+
+// matchTorrents returns the status of torrents matching the filter, optionally restricted to ids.
+func (f *TorrentFilter) matchTorrents(conn deluge.DelugeClient, ids []string) (map[string]*deluge.TorrentStatus, error) {
+	var name *regexp.Regexp
+	if f.Name != "" {
+		var err error
+		name, err = regexp.Compile(f.Name)
+		if err != nil {
+			return nil, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Filter Name is not a valid regular expression: %s", err)}
+		}
+	}
+
+	torrents, err := conn.TorrentsStatus(f.State, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels map[string]string
+	if f.Label != "" {
+		plugin, err := labelPluginClient(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		labels, err = plugin.GetTorrentsLabels(f.State, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for id, t := range torrents {
+		switch {
+		case f.Label != "" && labels[id] != f.Label:
+		case f.TrackerHost != "" && t.TrackerHost != f.TrackerHost:
+		case name != nil && !name.MatchString(t.Name):
+		default:
+			continue
+		}
+
+		delete(torrents, id)
+	}
+
+	return torrents, nil
+}
+
+// sortedIDs returns the IDs of torrents in order.
+func sortedIDs(torrents map[string]*deluge.TorrentStatus) []string {
+	ids := make([]string, 0, len(torrents))
+	for id := range torrents {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,91 @@
+package storm
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+)
+
+// forwardAuthWarningsSize is the maximum number of addresses remembered as having sent an untrusted forward auth header.
+const forwardAuthWarningsSize = 1024
+
+// ForwardAuthConfig configures authentication by a reverse proxy that sets the name of the authenticated user in a header.
+type ForwardAuthConfig struct {
+	// Header contains the name of the user authenticated by the proxy, such as Remote-User.
+	// Forward authentication is disabled if empty.
+	Header string
+	// DefaultRole is the role of users that do not have a user account.
+	// If empty then only users with a user account are allowed.
+	DefaultRole Role
+}
+
+// Enabled returns true if forward authentication is configured.
+func (c ForwardAuthConfig) Enabled() bool {
+	return c.Header != ""
+}
+
+// forwardAuthWarnings remembers which addresses have sent an untrusted forward auth header
+// so that each address is only warned about once instead of on every request.
+type forwardAuthWarnings struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// first returns true the first time it is called for addr.
+// Once forwardAuthWarningsSize addresses have been seen it returns false for any new address.
+func (w *forwardAuthWarnings) first(addr string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.seen == nil {
+		w.seen = make(map[string]struct{})
+	}
+
+	if _, ok := w.seen[addr]; ok || len(w.seen) >= forwardAuthWarningsSize {
+		return false
+	}
+
+	w.seen[addr] = struct{}{}
+	return true
+}
+
+// authenticateForwarded returns the principal of a user authenticated by a trusted reverse proxy.
+// The boolean result is false if the request does not contain a trusted forward auth header.
+func (api *Api) authenticateForwarded(r *http.Request) (*Principal, bool, error) {
+	if !api.forwardAuth.Enabled() {
+		return nil, false, nil
+	}
+
+	name := r.Header.Get(api.forwardAuth.Header)
+	if name == "" {
+		return nil, false, nil
+	}
+
+	// Anyone can set the header, so it is only trusted from the reverse proxy itself
+	if !api.trustedProxies.FromTrustedProxy(r) {
+		level := api.log.Debug
+		if addr := remoteIP(r); addr != nil && api.forwardAuthWarned.first(addr.String()) {
+			level = api.log.Warn
+		}
+
+		level("Ignoring forward auth header from untrusted address",
+			zap.String("Header", api.forwardAuth.Header),
+			zap.String("RemoteAddr", r.RemoteAddr),
+		)
+		return nil, false, nil
+	}
+
+	if api.users != nil {
+		user, ok := api.users.Get(name)
+		if ok {
+			return &Principal{Name: user.Name, Role: user.Role}, true, nil
+		}
+	}
+
+	if api.forwardAuth.DefaultRole == "" {
+		return nil, true, &Error{Code: http.StatusForbidden, Message: fmt.Sprintf("User %q does not have a user account", name)}
+	}
+
+	return &Principal{Name: name, Role: api.forwardAuth.DefaultRole}, true, nil
+}
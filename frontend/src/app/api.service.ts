@@ -9,11 +9,11 @@ import {
   HttpParams,
   HttpRequest
 } from '@angular/common/http';
-import {catchError, retryWhen, switchMap, takeWhile} from 'rxjs/operators';
+import {catchError, last, retryWhen, switchMap, takeWhile} from 'rxjs/operators';
 import {Message} from 'primeng/api';
 import {Environment, ENVIRONMENT} from './environment';
 import {DialogService} from 'primeng/dynamicdialog';
-import {ApiKeyDialogComponent} from './components/api-key-dialog/api-key-dialog.component';
+import {ApiKeyDialogComponent, LoginCredentials} from './components/api-key-dialog/api-key-dialog.component';
 
 /**
  * Raised when the API returns an error
@@ -121,6 +121,7 @@ export interface AddTorrent {
 
 export interface AddTorrentRequest extends AddTorrent {
   Options?: TorrentOptions;
+  Label?: string;
 }
 
 export interface AddTorrentResponse {
@@ -175,9 +176,9 @@ export class ApiInterceptor implements HttpInterceptor {
 
 @Injectable()
 export class AuthInterceptor implements HttpInterceptor {
-  ask$: Observable<void>;
+  ask$: Observable<LoginCredentials>;
 
-  constructor(dialogService: DialogService) {
+  constructor(dialogService: DialogService, @Inject(ENVIRONMENT) private environment: Environment) {
     this.ask$ = defer(() => {
       const ref = dialogService.open(ApiKeyDialogComponent, {
         header: 'Authorization Required',
@@ -192,21 +193,20 @@ export class AuthInterceptor implements HttpInterceptor {
 
   public intercept(req: HttpRequest<any>, next: HttpHandler): Observable<HttpEvent<any>> {
     return next.handle(req).pipe(
-      // Catch 401 errors and ask for the API key.
-      // Redo the request with the provided API key in basic auth headers
+      // Catch 401 errors and ask for a username and password, or the API key.
+      // Log in using the provided credentials, which sets the session cookie, then redo the request
       catchError((err: ApiException) => {
         if (err.status !== 401) {
           return throwError(err);
         }
 
         return this.ask$.pipe(
-          switchMap(key => {
-            const withAuthHeaderReq = req.clone({
-              headers: req.headers.set('Authorization', 'Basic ' + btoa(':' + key))
-            });
+          switchMap(credentials => {
+            const loginReq = new HttpRequest('POST', `${this.environment.baseApiPath}login`, credentials);
 
-            return next.handle(withAuthHeaderReq);
-          })
+            return next.handle(loginReq).pipe(last());
+          }),
+          switchMap(() => next.handle(req))
         );
       }),
 
@@ -1,18 +1,30 @@
 import { Component } from '@angular/core';
 import {DynamicDialogRef} from "primeng/dynamicdialog";
 
+export interface LoginCredentials {
+  // Username is empty to log in with the API key
+  Username?: string;
+  Password: string;
+}
+
 @Component({
   selector: 't-api-key-dialog',
   templateUrl: './api-key-dialog.component.html',
   styleUrls: ['./api-key-dialog.component.scss']
 })
 export class ApiKeyDialogComponent{
-  key: string;
+  username: string;
+  password: string;
 
   constructor(private ref: DynamicDialogRef) { }
 
   onSubmit() {
-    this.ref.close(this.key)
+    const credentials: LoginCredentials = {Password: this.password};
+    if (this.username) {
+      credentials.Username = this.username;
+    }
+
+    this.ref.close(credentials)
   }
 
 }
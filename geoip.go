@@ -0,0 +1,99 @@
+package storm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+type geoIPRange struct {
+	start   net.IP
+	end     net.IP
+	country string
+}
+
+// OpenGeoIP loads an offline GeoIP database from a CSV file of IP address ranges,
+// where each row is in the form start_ip,end_ip,country_code.
+// This is the format of the free DB-IP "IP to Country Lite" database.
+func OpenGeoIP(path string) (*GeoIP, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var (
+		geo = new(GeoIP)
+		r   = csv.NewReader(f)
+	)
+
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GeoIP database %s: %w", path, err)
+		}
+
+		if len(record) < 3 {
+			return nil, fmt.Errorf("GeoIP database %s line %d: expected start_ip,end_ip,country_code", path, line)
+		}
+
+		start, end := net.ParseIP(strings.TrimSpace(record[0])), net.ParseIP(strings.TrimSpace(record[1]))
+		if start == nil || end == nil {
+			// Allow a header row
+			if line == 1 {
+				continue
+			}
+
+			return nil, fmt.Errorf("GeoIP database %s line %d: invalid IP address range", path, line)
+		}
+
+		geo.ranges = append(geo.ranges, geoIPRange{
+			start:   start.To16(),
+			end:     end.To16(),
+			country: strings.ToUpper(strings.TrimSpace(record[2])),
+		})
+	}
+
+	sort.Slice(geo.ranges, func(i, j int) bool {
+		return bytes.Compare(geo.ranges[i].start, geo.ranges[j].start) < 0
+	})
+
+	return geo, nil
+}
+
+// GeoIP looks up the country of an IP address from an offline database.
+type GeoIP struct {
+	ranges []geoIPRange
+}
+
+// Country returns the ISO country code of ip, or an empty string if it is not known.
+func (g *GeoIP) Country(ip net.IP) string {
+	if g == nil || ip == nil {
+		return ""
+	}
+
+	ip = ip.To16()
+
+	// Find the last range that starts at or before ip
+	i := sort.Search(len(g.ranges), func(i int) bool {
+		return bytes.Compare(g.ranges[i].start, ip) > 0
+	}) - 1
+
+	if i < 0 || bytes.Compare(ip, g.ranges[i].end) > 0 {
+		return ""
+	}
+
+	return g.ranges[i].country
+}
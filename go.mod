@@ -8,6 +8,7 @@ require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/spf13/afero v1.6.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.14.0
 )
 
 require (
@@ -15,7 +16,7 @@ require (
 	github.com/stretchr/testify v1.5.1 // indirect
 	go.uber.org/atomic v1.6.0 // indirect
 	go.uber.org/multierr v1.5.0 // indirect
-	golang.org/x/text v0.3.3 // indirect
-	golang.org/x/tools v0.0.0-20200308013534-11ec41452d41 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
@@ -0,0 +1,332 @@
+package storm
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MetainfoFile is a file within a torrent.
+type MetainfoFile struct {
+	Path string
+	Size int64
+}
+
+// Metainfo is the content of a .torrent file.
+type Metainfo struct {
+	Name string
+	// InfoHashV1 is the SHA-1 infohash of a v1 or hybrid torrent
+	InfoHashV1 string `json:",omitempty"`
+	// InfoHashV2 is the SHA-256 infohash of a v2 or hybrid torrent
+	InfoHashV2 string `json:",omitempty"`
+	TotalSize  int64
+	Files      []MetainfoFile
+	// Trackers are the announce URLs of the torrent in order of preference
+	Trackers []string
+	Private  bool
+}
+
+// ID returns the ID that Deluge gives the torrent once added.
+// This is the v1 infohash, or the truncated v2 infohash of a v2 only torrent.
+func (m *Metainfo) ID() string {
+	if m.InfoHashV1 != "" {
+		return m.InfoHashV1
+	}
+
+	return m.InfoHashV2[:sha1.Size*2]
+}
+
+func bencodeString(dict map[string]interface{}, key string) (string, bool) {
+	b, ok := dict[key].([]byte)
+	return string(b), ok
+}
+
+// bencodeUTF8String gets a string, preferring the .utf-8 variant of key if present.
+func bencodeUTF8String(dict map[string]interface{}, key string) (string, bool) {
+	if s, ok := bencodeString(dict, key+".utf-8"); ok {
+		return s, true
+	}
+
+	return bencodeString(dict, key)
+}
+
+func bencodeInt(dict map[string]interface{}, key string) (int64, bool) {
+	n, ok := dict[key].(int64)
+	return n, ok
+}
+
+// metainfoPath joins the path components of a file.
+func metainfoPath(components interface{}) (string, error) {
+	list, ok := components.([]interface{})
+	if !ok || len(list) == 0 {
+		return "", fmt.Errorf("file path must be a list of strings")
+	}
+
+	parts := make([]string, 0, len(list))
+	for _, c := range list {
+		s, ok := c.([]byte)
+		if !ok {
+			return "", fmt.Errorf("file path must be a list of strings")
+		}
+
+		parts = append(parts, string(s))
+	}
+
+	return path.Join(parts...), nil
+}
+
+// metainfoV1Files reads the files of a v1 info dictionary, excluding padding files.
+func metainfoV1Files(info map[string]interface{}, name string) ([]MetainfoFile, error) {
+	if length, ok := bencodeInt(info, "length"); ok {
+		return []MetainfoFile{{Path: name, Size: length}}, nil
+	}
+
+	list, ok := info["files"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("info must contain either length or files")
+	}
+
+	files := make([]MetainfoFile, 0, len(list))
+	for _, f := range list {
+		file, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("files must be a list of dictionaries")
+		}
+
+		if attr, _ := bencodeString(file, "attr"); strings.Contains(attr, "p") {
+			continue
+		}
+
+		length, ok := bencodeInt(file, "length")
+		if !ok {
+			return nil, fmt.Errorf("file length must be an integer")
+		}
+
+		components, ok := file["path.utf-8"]
+		if !ok {
+			components = file["path"]
+		}
+
+		p, err := metainfoPath(components)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, MetainfoFile{Path: path.Join(name, p), Size: length})
+	}
+
+	return files, nil
+}
+
+// metainfoV2Files reads the files of a v2 file tree in order.
+func metainfoV2Files(tree map[string]interface{}, dir string, depth int) ([]MetainfoFile, error) {
+	if depth > bencodeMaxDepth {
+		return nil, fmt.Errorf("file tree is nested too deeply")
+	}
+
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var files []MetainfoFile
+	for _, name := range names {
+		node, ok := tree[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("file tree must only contain dictionaries")
+		}
+
+		// A file is a node containing a single empty key
+		if leaf, ok := node[""].(map[string]interface{}); ok {
+			length, ok := bencodeInt(leaf, "length")
+			if !ok {
+				return nil, fmt.Errorf("file length must be an integer")
+			}
+
+			files = append(files, MetainfoFile{Path: path.Join(dir, name), Size: length})
+			continue
+		}
+
+		children, err := metainfoV2Files(node, path.Join(dir, name), depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, children...)
+	}
+
+	return files, nil
+}
+
+// ParseMetainfo parses the content of a .torrent file.
+func ParseMetainfo(b []byte) (*Metainfo, error) {
+	raw, err := bencodeDictRaw(b)
+	if err != nil {
+		return nil, err
+	}
+
+	rawInfo, ok := raw["info"]
+	if !ok {
+		return nil, fmt.Errorf("torrent does not contain info")
+	}
+
+	v, err := decodeBencode(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	info, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("info must be a dictionary")
+	}
+
+	m := &Metainfo{
+		Files:    make([]MetainfoFile, 0),
+		Trackers: make([]string, 0),
+	}
+
+	m.Name, ok = bencodeUTF8String(info, "name")
+	if !ok || m.Name == "" {
+		return nil, fmt.Errorf("torrent does not have a name")
+	}
+
+	var (
+		_, v1      = info["pieces"]
+		version, _ = bencodeInt(info, "meta version")
+		v2         = version == 2
+	)
+
+	if !v1 && !v2 {
+		return nil, fmt.Errorf("torrent does not contain pieces")
+	}
+
+	if v1 {
+		sum := sha1.Sum(rawInfo)
+		m.InfoHashV1 = hex.EncodeToString(sum[:])
+
+		m.Files, err = metainfoV1Files(info, m.Name)
+	}
+
+	if v2 {
+		sum := sha256.Sum256(rawInfo)
+		m.InfoHashV2 = hex.EncodeToString(sum[:])
+
+		if !v1 {
+			tree, ok := info["file tree"].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("v2 torrent does not contain a file tree")
+			}
+
+			m.Files, err = metainfoV2Files(tree, "", 0)
+
+			// A single file torrent has a file tree of only the torrent name
+			if err == nil && (len(m.Files) != 1 || m.Files[0].Path != m.Name) {
+				for i := range m.Files {
+					m.Files[i].Path = path.Join(m.Name, m.Files[i].Path)
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range m.Files {
+		m.TotalSize += f.Size
+	}
+
+	if private, _ := bencodeInt(info, "private"); private == 1 {
+		m.Private = true
+	}
+
+	// The rest of the torrent is not covered by the infohash, so ignore any of it that is not valid
+	seen := make(map[string]bool)
+	addTracker := func(v interface{}) {
+		if b, ok := v.([]byte); ok && len(b) > 0 && !seen[string(b)] {
+			seen[string(b)] = true
+			m.Trackers = append(m.Trackers, string(b))
+		}
+	}
+
+	if rawList, ok := raw["announce-list"]; ok {
+		if tiers, err := decodeBencode(rawList); err == nil {
+			tiers, _ := tiers.([]interface{})
+			for _, tier := range tiers {
+				tier, _ := tier.([]interface{})
+				for _, tracker := range tier {
+					addTracker(tracker)
+				}
+			}
+		}
+	}
+
+	// announce is ignored if announce-list contains any trackers (BEP 12)
+	if rawAnnounce, ok := raw["announce"]; ok && len(m.Trackers) == 0 {
+		if announce, err := decodeBencode(rawAnnounce); err == nil {
+			addTracker(announce)
+		}
+	}
+
+	return m, nil
+}
+
+type InspectTorrentRequest struct {
+	// Data is the base64 encoded content of a .torrent file
+	Data string
+}
+
+type InspectTorrentResponse struct {
+	Metainfo
+	// Duplicate is true if the torrent has already been added to the daemon
+	Duplicate bool
+}
+
+// readMetainfo decodes and parses a base64 encoded .torrent file.
+func readMetainfo(data string) (*Metainfo, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Torrent Data must be base64 encoded"}
+	}
+
+	m, err := ParseMetainfo(b)
+	if err != nil {
+		return nil, &Error{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Torrent file is not valid: %s", err)}
+	}
+
+	return m, nil
+}
+
+// httpInspectTorrent reads the content of a .torrent file without adding it
+func httpInspectTorrent(conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
+	var req InspectTorrentRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := readMetainfo(req.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := conn.TorrentsStatus(deluge.StateUnspecified, []string{m.ID()})
+	if err != nil {
+		return nil, err
+	}
+
+	return InspectTorrentResponse{
+		Metainfo:  *m,
+		Duplicate: len(existing) > 0,
+	}, nil
+}
@@ -0,0 +1,262 @@
+package storm
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bdict = map[string]interface{}
+type blist = []interface{}
+
+// testPieces is a single v1 piece hash.
+var testPieces = strings.Repeat("x", sha1.Size)
+
+// v2File returns a v2 file tree leaf of length bytes.
+func v2File(length int) bdict {
+	return bdict{"": bdict{"length": length, "pieces root": strings.Repeat("r", sha256.Size)}}
+}
+
+func sha1Hex(b []byte) string {
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseMetainfo(t *testing.T) {
+	var (
+		v1Single = bdict{"name": "file.iso", "piece length": 16384, "pieces": testPieces, "length": 1000}
+		v1Multi  = bdict{"name": "album", "piece length": 16384, "pieces": testPieces, "files": blist{
+			bdict{"length": 100, "path": blist{"cd1", "01.flac"}},
+			bdict{"length": 20, "path": blist{".pad", "20"}, "attr": "p"},
+			bdict{"length": 200, "path": blist{"cd2", "01.flac"}, "path.utf-8": blist{"cd2", "01 ünïcode.flac"}},
+		}}
+		v2Single = bdict{"name": "file.iso", "piece length": 16384, "meta version": 2, "file tree": bdict{
+			"file.iso": v2File(1000),
+		}}
+		v2Multi = bdict{"name": "album", "piece length": 16384, "meta version": 2, "file tree": bdict{
+			"cd2": bdict{"01.flac": v2File(200)},
+			"cd1": bdict{"02.flac": v2File(50), "01.flac": v2File(100)},
+		}}
+		hybrid = bdict{"name": "album", "piece length": 16384, "meta version": 2, "pieces": testPieces,
+			"files": blist{
+				bdict{"length": 100, "path": blist{"01.flac"}},
+				bdict{"length": 16284, "path": blist{".pad", "16284"}, "attr": "p"},
+				bdict{"length": 200, "path": blist{"02.flac"}},
+			},
+			"file tree": bdict{
+				"01.flac": v2File(100),
+				"02.flac": v2File(200),
+			},
+		}
+	)
+
+	tests := []struct {
+		name    string
+		torrent bdict
+		info    bdict
+		want    Metainfo
+	}{
+		{
+			name:    "v1 single file",
+			torrent: bdict{"announce": "https://tracker.example.com/announce"},
+			info:    v1Single,
+			want: Metainfo{
+				Name:      "file.iso",
+				TotalSize: 1000,
+				Files:     []MetainfoFile{{Path: "file.iso", Size: 1000}},
+				Trackers:  []string{"https://tracker.example.com/announce"},
+			},
+		},
+		{
+			name:    "v1 multiple files without padding",
+			torrent: bdict{},
+			info:    v1Multi,
+			want: Metainfo{
+				Name:      "album",
+				TotalSize: 300,
+				Files: []MetainfoFile{
+					{Path: "album/cd1/01.flac", Size: 100},
+					{Path: "album/cd2/01 ünïcode.flac", Size: 200},
+				},
+				Trackers: []string{},
+			},
+		},
+		{
+			name:    "v2 only single file",
+			torrent: bdict{},
+			info:    v2Single,
+			want: Metainfo{
+				Name:      "file.iso",
+				TotalSize: 1000,
+				Files:     []MetainfoFile{{Path: "file.iso", Size: 1000}},
+				Trackers:  []string{},
+			},
+		},
+		{
+			name:    "v2 only multiple files in order",
+			torrent: bdict{},
+			info:    v2Multi,
+			want: Metainfo{
+				Name:      "album",
+				TotalSize: 350,
+				Files: []MetainfoFile{
+					{Path: "album/cd1/01.flac", Size: 100},
+					{Path: "album/cd1/02.flac", Size: 50},
+					{Path: "album/cd2/01.flac", Size: 200},
+				},
+				Trackers: []string{},
+			},
+		},
+		{
+			name:    "hybrid without padding",
+			torrent: bdict{},
+			info:    hybrid,
+			want: Metainfo{
+				Name:      "album",
+				TotalSize: 300,
+				Files: []MetainfoFile{
+					{Path: "album/01.flac", Size: 100},
+					{Path: "album/02.flac", Size: 200},
+				},
+				Trackers: []string{},
+			},
+		},
+		{
+			name: "announce ignored with announce-list",
+			torrent: bdict{
+				"announce":      "https://announce.example.com/announce",
+				"announce-list": blist{blist{"https://a.example.com/announce", "udp://b.example.com:80"}, blist{"https://c.example.com/announce", "https://a.example.com/announce"}},
+			},
+			info: v1Single,
+			want: Metainfo{
+				Name:      "file.iso",
+				TotalSize: 1000,
+				Files:     []MetainfoFile{{Path: "file.iso", Size: 1000}},
+				Trackers:  []string{"https://a.example.com/announce", "udp://b.example.com:80", "https://c.example.com/announce"},
+			},
+		},
+		{
+			name:    "announce used with empty announce-list",
+			torrent: bdict{"announce": "https://announce.example.com/announce", "announce-list": blist{blist{}}},
+			info:    v1Single,
+			want: Metainfo{
+				Name:      "file.iso",
+				TotalSize: 1000,
+				Files:     []MetainfoFile{{Path: "file.iso", Size: 1000}},
+				Trackers:  []string{"https://announce.example.com/announce"},
+			},
+		},
+		{
+			name:    "invalid trackers ignored",
+			torrent: bdict{"announce": 1, "announce-list": "not a list"},
+			info:    v1Single,
+			want: Metainfo{
+				Name:      "file.iso",
+				TotalSize: 1000,
+				Files:     []MetainfoFile{{Path: "file.iso", Size: 1000}},
+				Trackers:  []string{},
+			},
+		},
+		{
+			name:    "private",
+			torrent: bdict{},
+			info:    bdict{"name": "file.iso", "piece length": 16384, "pieces": testPieces, "length": 1000, "private": 1},
+			want: Metainfo{
+				Name:      "file.iso",
+				TotalSize: 1000,
+				Files:     []MetainfoFile{{Path: "file.iso", Size: 1000}},
+				Trackers:  []string{},
+				Private:   true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				info    = bencode(tt.info)
+				torrent = bdict{"info": tt.info}
+			)
+
+			for key, value := range tt.torrent {
+				torrent[key] = value
+			}
+
+			if _, ok := tt.info["pieces"]; ok {
+				tt.want.InfoHashV1 = sha1Hex(info)
+			}
+			if _, ok := tt.info["meta version"]; ok {
+				tt.want.InfoHashV2 = sha256Hex(info)
+			}
+
+			got, err := ParseMetainfo(bencode(torrent))
+			if err != nil {
+				t.Fatalf("ParseMetainfo() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseMetainfo() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetainfoID(t *testing.T) {
+	v1 := &Metainfo{InfoHashV1: sha1Hex([]byte("v1")), InfoHashV2: sha256Hex([]byte("v2"))}
+	if v1.ID() != v1.InfoHashV1 {
+		t.Errorf("ID() of a hybrid torrent = %s, want the v1 infohash", v1.ID())
+	}
+
+	v2 := &Metainfo{InfoHashV2: sha256Hex([]byte("v2"))}
+	if v2.ID() != v2.InfoHashV2[:40] {
+		t.Errorf("ID() of a v2 only torrent = %s, want the truncated v2 infohash", v2.ID())
+	}
+}
+
+func TestParseMetainfoInvalid(t *testing.T) {
+	valid := bencode(bdict{"info": bdict{"name": "file.iso", "piece length": 16384, "pieces": testPieces, "length": 1000}})
+
+	nested := bdict{"": v2File(1)}
+	for i := 0; i < bencodeMaxDepth; i++ {
+		nested = bdict{"dir": nested}
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", nil},
+		{"not a dictionary", []byte("l4:infoe")},
+		{"truncated", valid[:len(valid)-10]},
+		{"trailing data", append(append([]byte(nil), valid...), 'x')},
+		{"no info", bencode(bdict{"announce": "https://tracker.example.com/announce"})},
+		{"info not a dictionary", bencode(bdict{"info": "file.iso"})},
+		{"no name", bencode(bdict{"info": bdict{"pieces": testPieces, "length": 1}})},
+		{"no pieces", bencode(bdict{"info": bdict{"name": "file.iso", "length": 1}})},
+		{"no length or files", bencode(bdict{"info": bdict{"name": "file.iso", "pieces": testPieces}})},
+		{"file without length", bencode(bdict{"info": bdict{"name": "album", "pieces": testPieces, "files": blist{bdict{"path": blist{"a"}}}}})},
+		{"file with empty path", bencode(bdict{"info": bdict{"name": "album", "pieces": testPieces, "files": blist{bdict{"length": 1, "path": blist{}}}}})},
+		{"files not dictionaries", bencode(bdict{"info": bdict{"name": "album", "pieces": testPieces, "files": blist{"a"}}})},
+		{"v2 without file tree", bencode(bdict{"info": bdict{"name": "file.iso", "meta version": 2}})},
+		{"v2 file tree not dictionaries", bencode(bdict{"info": bdict{"name": "album", "meta version": 2, "file tree": bdict{"a": "b"}}})},
+		{"v2 file tree too deep", bencode(bdict{"info": bdict{"name": "album", "meta version": 2, "file tree": nested}})},
+		{"nested too deeply", []byte("d4:info" + strings.Repeat("l", bencodeMaxDepth+1) + strings.Repeat("e", bencodeMaxDepth+1) + "e")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMetainfo(tt.input)
+			if err == nil {
+				t.Errorf("ParseMetainfo() expected an error")
+			}
+		})
+	}
+}
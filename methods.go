@@ -40,6 +40,13 @@ func httpDeleteTorrents(conn deluge.DelugeClient, r *http.Request) (interface{},
 		return nil, err
 	}
 
+	if rmFiles {
+		err = authorize(r, PermTorrentsDeleteFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	errors, err := conn.RemoveTorrents(ids, rmFiles)
 	if err != nil {
 		return nil, err
@@ -70,12 +77,54 @@ func httpResumeTorrents(conn deluge.DelugeClient, r *http.Request) (interface{},
 	return nil, conn.ResumeTorrents(ids...)
 }
 
+// TorrentOutcome is the result of performing an action on a single torrent.
+type TorrentOutcome struct {
+	ID    string
+	OK    bool
+	Error string `json:",omitempty"`
+}
+
+// eachTorrent calls f for each torrent and returns the outcome for each.
+// Errors returned by the Deluge daemon are reported in the outcome, any other error stops processing.
+func eachTorrent(ids []string, f func(id string) error) ([]TorrentOutcome, error) {
+	outcomes := make([]TorrentOutcome, 0, len(ids))
+	for _, id := range ids {
+		err := f(id)
+		if rpcErr, ok := err.(deluge.RPCError); ok {
+			outcomes = append(outcomes, TorrentOutcome{ID: id, Error: rpcError(rpcErr).Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		outcomes = append(outcomes, TorrentOutcome{ID: id, OK: true})
+	}
+
+	return outcomes, nil
+}
+
+func httpReannounceTorrents(conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
+	ids, err := torrentIDs(r.URL.Query(), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reannounce each torrent separately so that one unknown torrent does not fail the others
+	return eachTorrent(ids, func(id string) error {
+		return conn.ForceReannounce([]string{id})
+	})
+}
+
 type AddTorrentRequest struct {
 	Type string
 	URI  string
 	Data string
 
 	Options deluge.Options
+	// Label is applied to the torrent once added if not empty.
+	// If the label cannot be applied then the torrent is removed again.
+	Label string
 }
 
 type AddTorrentResponse struct {
@@ -90,6 +139,13 @@ func httpAddTorrent(conn deluge.DelugeClient, r *http.Request) (interface{}, err
 		return nil, err
 	}
 
+	if req.Label != "" {
+		err = authorize(r, PermLabelsWrite)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var id string
 	switch req.Type {
 	case "url":
@@ -97,6 +153,11 @@ func httpAddTorrent(conn deluge.DelugeClient, r *http.Request) (interface{}, err
 	case "magnet":
 		id, err = conn.AddTorrentMagnet(req.URI, &req.Options)
 	case "file":
+		_, err = readMetainfo(req.Data)
+		if err != nil {
+			return nil, err
+		}
+
 		id, err = conn.AddTorrentFile(req.URI, req.Data, &req.Options)
 	default:
 		return nil, &Error{Code: http.StatusBadRequest, Message: "Torrent Type must be one of url, magnet or file"}
@@ -111,9 +172,35 @@ func httpAddTorrent(conn deluge.DelugeClient, r *http.Request) (interface{}, err
 		return nil, &Error{Code: http.StatusUnprocessableEntity, Message: "Torrent file could not be read"}
 	}
 
+	if req.Label != "" {
+		err = setAddedTorrentLabel(conn, id, req.Label)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return AddTorrentResponse{ID: id}, nil
 }
 
+// setAddedTorrentLabel sets the label of a torrent that has just been added.
+// If the label cannot be set then the torrent is removed so that the add fails as a whole.
+func setAddedTorrentLabel(conn deluge.DelugeClient, id, label string) error {
+	plugin, err := labelPluginClient(conn)
+	if err == nil {
+		err = plugin.SetTorrentLabel(id, label)
+	}
+	if err == nil {
+		return nil
+	}
+
+	_, rmErr := conn.RemoveTorrent(id, false)
+	if rmErr != nil {
+		return fmt.Errorf("failed to set label %q (%s) and the torrent could not be removed: %w", label, rpcError(err), rpcError(rmErr))
+	}
+
+	return &Error{Code: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Failed to set label %q, the torrent was not added: %s", label, rpcError(err))}
+}
+
 type TorrentMethod func(id string, conn deluge.DelugeClient, r *http.Request) (interface{}, error)
 
 func TorrentHandler(f TorrentMethod) DelugeMethod {
@@ -128,7 +215,15 @@ func httpTorrentStatus(id string, conn deluge.DelugeClient, _ *http.Request) (in
 }
 
 func httpDeleteTorrent(id string, conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
-	ok, err := conn.RemoveTorrent(id, r.URL.Query().Get("files") == "true")
+	rmFiles := r.URL.Query().Get("files") == "true"
+	if rmFiles {
+		err := authorize(r, PermTorrentsDeleteFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ok, err := conn.RemoveTorrent(id, rmFiles)
 	if err != nil {
 		return nil, err
 	}
@@ -148,6 +243,64 @@ func httpResumeTorrent(id string, conn deluge.DelugeClient, _ *http.Request) (in
 	return nil, conn.ResumeTorrents(id)
 }
 
+func httpReannounceTorrent(id string, conn deluge.DelugeClient, _ *http.Request) (interface{}, error) {
+	return nil, conn.ForceReannounce([]string{id})
+}
+
+type SetTorrentsOptionsRequest struct {
+	// Filter selects the torrents to change, restricted to the torrents in the id query if given
+	Filter  *TorrentFilter
+	Options deluge.Options
+}
+
+// httpSetTorrentsOptions sets the options of the torrents given in the id query and/or matching a filter
+func httpSetTorrentsOptions(conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
+	var req SetTorrentsOptionsRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := r.URL.Query()["id"]
+	if len(ids) == 0 && req.Filter == nil {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "At least 1 torrent ID or a Filter is required"}
+	}
+
+	filter := req.Filter
+	if filter == nil {
+		filter = new(TorrentFilter)
+	} else {
+		err = filter.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	torrents, err := filter.matchTorrents(conn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes, err := eachTorrent(sortedIDs(torrents), func(id string) error {
+		return conn.SetTorrentOptions(id, &req.Options)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Report torrents that were explicitly requested but do not exist
+	if req.Filter == nil {
+		for _, id := range ids {
+			if _, ok := torrents[id]; !ok {
+				outcomes = append(outcomes, TorrentOutcome{ID: id, Error: "Torrent does not exist"})
+			}
+		}
+	}
+
+	return outcomes, nil
+}
+
 func httpSetTorrentOptions(id string, conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
 	var req deluge.Options
 
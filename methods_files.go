@@ -0,0 +1,146 @@
+package storm
+
+import (
+	deluge "github.com/gdm85/go-libdeluge"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	FilePrioritySkip   = "skip"
+	FilePriorityLow    = "low"
+	FilePriorityNormal = "normal"
+	FilePriorityHigh   = "high"
+	// FilePriorityMixed is the priority of a directory containing files with different priorities
+	FilePriorityMixed = "mixed"
+)
+
+// filePriority returns the name of a Deluge file priority.
+// Deluge priorities range from 0 (skip) to 7 (high).
+func filePriority(p int64) string {
+	switch {
+	case p <= 0:
+		return FilePrioritySkip
+	case p < 4:
+		return FilePriorityLow
+	case p < 7:
+		return FilePriorityNormal
+	default:
+		return FilePriorityHigh
+	}
+}
+
+// FileNode is a file or directory in the file tree of a torrent.
+type FileNode struct {
+	Name string
+	Path string
+	// Index is the index of the file in the torrent. Directories do not have an index.
+	Index *int64 `json:",omitempty"`
+	Size  int64
+	// Progress is the percentage of the file or directory that has been downloaded
+	Progress float32
+	Priority string
+	// Children are the files and directories contained by a directory, sorted by name.
+	Children []*FileNode `json:",omitempty"`
+
+	done float64
+}
+
+func (n *FileNode) child(name, path string) *FileNode {
+	for _, c := range n.Children {
+		if c.Name == name && c.Index == nil {
+			return c
+		}
+	}
+
+	c := &FileNode{Name: name, Path: path}
+	n.Children = append(n.Children, c)
+
+	return c
+}
+
+// summarize calculates the size, progress and priority of directories from their children.
+func (n *FileNode) summarize() {
+	if n.Index != nil {
+		return
+	}
+
+	n.Size, n.done, n.Priority = 0, 0, ""
+	for _, c := range n.Children {
+		c.summarize()
+
+		n.Size += c.Size
+		n.done += c.done
+
+		switch n.Priority {
+		case "":
+			n.Priority = c.Priority
+		case c.Priority:
+		default:
+			n.Priority = FilePriorityMixed
+		}
+	}
+
+	if n.Size > 0 {
+		n.Progress = float32(n.done / float64(n.Size) * 100)
+	}
+
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+}
+
+// fileTree builds the tree of files in a torrent from its status.
+func fileTree(status *deluge.TorrentStatus) []*FileNode {
+	root := new(FileNode)
+
+	for i, f := range status.Files {
+		var (
+			parts = strings.Split(strings.Trim(f.Path, "/"), "/")
+			dir   = root
+		)
+
+		for n, name := range parts[:len(parts)-1] {
+			dir = dir.child(name, strings.Join(parts[:n+1], "/"))
+		}
+
+		index := f.Index
+		file := &FileNode{
+			Name:     parts[len(parts)-1],
+			Path:     f.Path,
+			Index:    &index,
+			Size:     f.Size,
+			Priority: FilePriorityNormal,
+		}
+
+		if i < len(status.FileProgress) {
+			// Deluge reports file progress as a fraction
+			file.Progress = status.FileProgress[i] * 100
+			file.done = float64(status.FileProgress[i]) * float64(f.Size)
+		}
+		if i < len(status.FilePriorities) {
+			file.Priority = filePriority(status.FilePriorities[i])
+		}
+
+		dir.Children = append(dir.Children, file)
+	}
+
+	root.summarize()
+
+	return root.Children
+}
+
+func httpTorrentFiles(id string, conn deluge.DelugeClient, _ *http.Request) (interface{}, error) {
+	status, err := conn.TorrentStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deluge returns an empty status for unknown torrents
+	if status.Name == "" {
+		return nil, &Error{Code: http.StatusNotFound, Message: "Torrent does not exist"}
+	}
+
+	return fileTree(status), nil
+}
@@ -0,0 +1,112 @@
+package storm
+
+import (
+	deluge "github.com/gdm85/go-libdeluge"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// PeerResponse is a peer connected to a torrent.
+type PeerResponse struct {
+	Address string
+	IP      string
+	Client  string
+	Country string `json:",omitempty"`
+	// Progress is the percentage of the torrent that the peer has
+	Progress  float32
+	Seed      bool
+	DownSpeed int64
+	UpSpeed   int64
+}
+
+// PeerSummary aggregates the peers of a torrent.
+type PeerSummary struct {
+	Total    int
+	Seeds    int
+	Leechers int
+	// Clients is the number of peers using each client software, regardless of version
+	Clients map[string]int
+	// Countries is the number of peers in each country, if known
+	Countries map[string]int
+}
+
+type PeersResponse struct {
+	Peers   []PeerResponse
+	Summary PeerSummary
+}
+
+// clientSoftware returns the name of a peer client without its version, such as qBittorrent for "qBittorrent 4.3.1".
+func clientSoftware(client string) string {
+	client = strings.TrimSpace(client)
+
+	i := strings.LastIndexByte(client, ' ')
+	if i < 0 {
+		return client
+	}
+
+	version := strings.TrimPrefix(strings.ToLower(client[i+1:]), "v")
+	if version != "" && version[0] >= '0' && version[0] <= '9' {
+		return client[:i]
+	}
+
+	return client
+}
+
+func (api *Api) httpTorrentPeers(id string, conn deluge.DelugeClient, _ *http.Request) (interface{}, error) {
+	status, err := conn.TorrentStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deluge returns an empty status for unknown torrents
+	if status.Name == "" {
+		return nil, &Error{Code: http.StatusNotFound, Message: "Torrent does not exist"}
+	}
+
+	response := PeersResponse{
+		Peers: make([]PeerResponse, 0, len(status.Peers)),
+		Summary: PeerSummary{
+			Clients:   make(map[string]int),
+			Countries: make(map[string]int),
+		},
+	}
+
+	for _, p := range status.Peers {
+		peer := PeerResponse{
+			Address:   p.IP,
+			IP:        p.IP,
+			Client:    p.Client,
+			Country:   strings.TrimSpace(p.Country),
+			Progress:  p.Progress * 100,
+			Seed:      p.Seed != 0,
+			DownSpeed: p.DownSpeed,
+			UpSpeed:   p.UpSpeed,
+		}
+
+		if host, _, err := net.SplitHostPort(p.IP); err == nil {
+			peer.IP = host
+		}
+
+		// Deluge only reports the country if GeoIP is installed on the daemon
+		if peer.Country == "" {
+			peer.Country = api.geoIP.Country(net.ParseIP(peer.IP))
+		}
+
+		response.Peers = append(response.Peers, peer)
+
+		response.Summary.Total++
+		if peer.Seed {
+			response.Summary.Seeds++
+		} else {
+			response.Summary.Leechers++
+		}
+
+		response.Summary.Clients[clientSoftware(peer.Client)]++
+		if peer.Country != "" {
+			response.Summary.Countries[peer.Country]++
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,155 @@
+package storm
+
+import (
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"net/http"
+	"net/url"
+)
+
+// TrackerResponse is the tracker that a torrent is currently announcing to.
+// Deluge only reports the host name of the current tracker, not the full list of trackers.
+type TrackerResponse struct {
+	Host         string
+	Status       string
+	NextAnnounce int64
+}
+
+type SetTrackerRequest struct {
+	URL string
+	// ReplaceAll must be true to confirm that every other tracker of the torrent will be removed
+	ReplaceAll bool
+}
+
+type SetTrackerResponse struct {
+	URL string
+	// Replaced is the host of the tracker the torrent was announcing to.
+	// It and any other trackers of the torrent have been removed.
+	Replaced string
+}
+
+type ReplaceTrackersRequest struct {
+	// Host is the tracker host to replace, as reported in TrackerHost of the torrent status
+	Host string
+	URL  string
+	// ReplaceAll must be true to confirm that every other tracker of each matching torrent will be removed
+	ReplaceAll bool
+	// DryRun returns the torrents that would be changed without changing them
+	DryRun bool
+}
+
+// validateTrackerURL checks that u is an absolute tracker announce URL.
+func validateTrackerURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Tracker URL %q is not valid", u)}
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "udp":
+		return nil
+	default:
+		return &Error{Code: http.StatusBadRequest, Message: "Tracker URL must use http, https or udp"}
+	}
+}
+
+func httpTorrentTrackers(id string, conn deluge.DelugeClient, _ *http.Request) (interface{}, error) {
+	status, err := conn.TorrentStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deluge returns an empty status for unknown torrents
+	if status.Name == "" {
+		return nil, &Error{Code: http.StatusNotFound, Message: "Torrent does not exist"}
+	}
+
+	trackers := make([]TrackerResponse, 0, 1)
+	if status.TrackerHost != "" {
+		trackers = append(trackers, TrackerResponse{
+			Host:         status.TrackerHost,
+			Status:       status.TrackerStatus,
+			NextAnnounce: status.NextAnnounce,
+		})
+	}
+
+	return trackers, nil
+}
+
+// httpSetTorrentTracker replaces all trackers of the torrent with a single tracker.
+// Deluge does not report the full list of trackers, so the request must confirm that they will all be removed.
+func httpSetTorrentTracker(id string, conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
+	var req SetTrackerRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateTrackerURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.ReplaceAll {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Setting the tracker removes every other tracker of the torrent, set ReplaceAll to confirm"}
+	}
+
+	status, err := conn.TorrentStatus(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.Name == "" {
+		return nil, &Error{Code: http.StatusNotFound, Message: "Torrent does not exist"}
+	}
+
+	err = conn.SetTorrentTracker(id, req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return SetTrackerResponse{
+		URL:      req.URL,
+		Replaced: status.TrackerHost,
+	}, nil
+}
+
+// httpReplaceTrackers replaces all trackers of every torrent announcing to a tracker host with a single tracker.
+// Like httpSetTorrentTracker the request must confirm that every other tracker of those torrents will be removed.
+func httpReplaceTrackers(conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
+	var req ReplaceTrackersRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Host == "" {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Tracker Host is required"}
+	}
+
+	err = validateTrackerURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.ReplaceAll {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Replacing the tracker removes every other tracker of the matching torrents, set ReplaceAll to confirm"}
+	}
+
+	filter := TorrentFilter{TrackerHost: req.Host}
+
+	torrents, err := filter.matchTorrents(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return eachTorrent(sortedIDs(torrents), func(id string) error {
+		if req.DryRun {
+			return nil
+		}
+
+		return conn.SetTorrentTracker(id, req.URL)
+	})
+}
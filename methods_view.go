@@ -1,24 +1,38 @@
 package storm
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
 	deluge "github.com/gdm85/go-libdeluge"
+	"github.com/gorilla/mux"
 	"net/http"
 	"sort"
+	"sync"
 )
 
 type ViewTorrent struct {
-	Hash  string
-	Label string
+	Hash   string
+	Daemon string
+	Label  string
 	*deluge.TorrentStatus
 }
 
+// DaemonView is the session state of a single daemon within a ViewUpdate.
+type DaemonView struct {
+	Session  *deluge.SessionStatus
+	DiskFree int64
+	Error    string `json:",omitempty"`
+}
+
 type ViewUpdate struct {
 	Torrents []*ViewTorrent
 	Session  *deluge.SessionStatus
+	// DiskFree is the free space of the first daemon in the view, which is the default daemon when the view includes all daemons.
+	// Daemons may be on different hosts and volumes so their free space is not added together, see the DiskFree of each daemon instead.
 	DiskFree int64
+	Daemons  map[string]*DaemonView
 }
 
 type ViewUpdateResponse struct {
@@ -26,59 +40,182 @@ type ViewUpdateResponse struct {
 	ETag string
 }
 
-func httpViewUpdate(conn deluge.DelugeClient, r *http.Request) (interface{}, error) {
-	var (
-		q     = r.URL.Query()
-		ids   = q["id"]
-		state = deluge.TorrentState(q.Get("state"))
-	)
+// viewRequest describes which torrents should be included in a view.
+type viewRequest struct {
+	ids   []string
+	state deluge.TorrentState
+	path  string
+}
 
-	torrents, err := conn.TorrentsStatus(state, ids)
+// daemonView fetches the torrents and session state of a single daemon.
+func daemonView(name string, conn deluge.DelugeClient, req *viewRequest) ([]*ViewTorrent, *DaemonView, error) {
+	torrents, err := conn.TorrentsStatus(req.state, req.ids)
 	if err != nil {
-		return nil, err
-	}
-
-	var torrentHashes = make([]string, 0, len(torrents))
-	for k := range torrents {
-		torrentHashes = append(torrentHashes, k)
+		return nil, nil, err
 	}
-	sort.Strings(torrentHashes)
 
 	var torrentLabels = make(map[string]string)
 
 	plugin, err := labelPluginClient(conn)
 	if err == nil {
-		labels, err := plugin.GetTorrentsLabels(state, ids)
+		labels, err := plugin.GetTorrentsLabels(req.state, req.ids)
 		if err == nil {
 			torrentLabels = labels
 		}
 	}
 
 	var responseTorrents = make([]*ViewTorrent, 0, len(torrents))
-	for _, k := range torrentHashes {
+	for k, status := range torrents {
 		responseTorrents = append(responseTorrents, &ViewTorrent{
 			Hash:          k,
+			Daemon:        name,
 			Label:         torrentLabels[k],
-			TorrentStatus: torrents[k],
+			TorrentStatus: status,
 		})
 	}
 
 	session, err := conn.GetSessionStatus()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	diskFree, err := conn.GetFreeSpace(q.Get("path"))
+	diskFree, _ := conn.GetFreeSpace(req.path)
 
-	update := ViewUpdate{
-		Torrents: responseTorrents,
+	return responseTorrents, &DaemonView{
 		Session:  session,
 		DiskFree: diskFree,
+	}, nil
+}
+
+// addSessionStatus adds the statistics of session into total.
+func addSessionStatus(total, session *deluge.SessionStatus) {
+	total.HasIncomingConnections = total.HasIncomingConnections || session.HasIncomingConnections
+	total.UploadRate += session.UploadRate
+	total.DownloadRate += session.DownloadRate
+	total.PayloadUploadRate += session.PayloadUploadRate
+	total.PayloadDownloadRate += session.PayloadDownloadRate
+	total.TotalDownload += session.TotalDownload
+	total.TotalUpload += session.TotalUpload
+	total.NumPeers += session.NumPeers
+	total.DhtNodes += session.DhtNodes
+}
+
+// view builds a ViewUpdate from the named daemons.
+// Daemons that could not be reached are reported in the update,
+// an error is only returned if no daemon could be reached.
+func (api *Api) view(ctx context.Context, names []string, req *viewRequest) (*ViewUpdate, error) {
+	type result struct {
+		torrents []*ViewTorrent
+		view     *DaemonView
+		err      error
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]result, len(names))
+	)
+
+	for i, name := range names {
+		pool, err := api.daemons.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		wg.Add(1)
+		go func(i int, name string, pool *ConnectionPool) {
+			defer wg.Done()
+
+			res := &results[i]
+			res.err = rpcError(pool.Do(ctx, func(conn deluge.DelugeClient) (err error) {
+				res.torrents, res.view, err = daemonView(name, conn, req)
+				return
+			}))
+		}(i, name, pool)
+	}
+
+	wg.Wait()
+
+	var (
+		update = &ViewUpdate{
+			Torrents: make([]*ViewTorrent, 0),
+			Session:  new(deluge.SessionStatus),
+			Daemons:  make(map[string]*DaemonView, len(names)),
+		}
+		firstErr error
+		ok       bool
+	)
+
+	for i, name := range names {
+		res := results[i]
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+
+			update.Daemons[name] = &DaemonView{Error: res.err.Error()}
+			continue
+		}
+
+		ok = true
+		update.Torrents = append(update.Torrents, res.torrents...)
+		update.Daemons[name] = res.view
+		if i == 0 {
+			update.DiskFree = res.view.DiskFree
+		}
+		addSessionStatus(update.Session, res.view.Session)
+	}
+
+	if !ok && firstErr != nil {
+		return nil, firstErr
+	}
+
+	sortViewTorrents(update.Torrents)
+
+	return update, nil
+}
+
+// sortViewTorrents sorts torrents by their hash and then their daemon.
+func sortViewTorrents(torrents []*ViewTorrent) {
+	sort.Slice(torrents, func(i, j int) bool {
+		a, b := torrents[i], torrents[j]
+		if a.Hash == b.Hash {
+			return a.Daemon < b.Daemon
+		}
+		return a.Hash < b.Hash
+	})
+}
+
+// httpViewUpdate gets the view of the default daemon, or of the daemon selected by the route.
+//
+//	?daemon=	Aggregate the view from these daemons instead (can be repeated)
+//	?all	Aggregate the view from all daemons
+func (api *Api) httpViewUpdate(r *http.Request) (interface{}, error) {
+	var (
+		q   = r.URL.Query()
+		req = &viewRequest{
+			ids:   q["id"],
+			state: deluge.TorrentState(q.Get("state")),
+			path:  q.Get("path"),
+		}
+		names = []string{api.daemons.Default()}
+	)
+
+	if daemon, ok := mux.Vars(r)["daemon"]; ok {
+		names = []string{daemon}
+	} else if daemons := q["daemon"]; len(daemons) > 0 {
+		names = daemons
+	} else if _, all := q["all"]; all {
+		names = api.daemons.Names()
+	}
+
+	update, err := api.view(r.Context(), names, req)
+	if err != nil {
+		return nil, err
 	}
 
 	// ETag calculation
 	var h = sha1.New()
-	_ = json.NewEncoder(h).Encode(&update)
+	_ = json.NewEncoder(h).Encode(update)
 
 	responseETag := hex.EncodeToString(h.Sum(nil))
 
@@ -90,7 +227,7 @@ func httpViewUpdate(conn deluge.DelugeClient, r *http.Request) (interface{}, err
 	}
 
 	return &ViewUpdateResponse{
-		ViewUpdate: update,
+		ViewUpdate: *update,
 		ETag:       responseETag,
 	}, nil
 }
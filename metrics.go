@@ -0,0 +1,410 @@
+package storm
+
+import (
+	"context"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"github.com/gorilla/mux"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsLatencyBuckets are the upper bounds in seconds of the HTTP request latency histogram.
+var metricsLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metricLabel is a single Prometheus label pair.
+type metricLabel struct {
+	Name  string
+	Value string
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricWriter writes metrics using the Prometheus text exposition format.
+type metricWriter struct {
+	w io.Writer
+}
+
+func (mw *metricWriter) family(name, typ, help string) {
+	_, _ = fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (mw *metricWriter) sample(name string, value float64, labels ...metricLabel) {
+	_, _ = io.WriteString(mw.w, name)
+
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels))
+		for _, l := range labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l.Name, metricLabelEscaper.Replace(l.Value)))
+		}
+
+		_, _ = fmt.Fprintf(mw.w, "{%s}", strings.Join(pairs, ","))
+	}
+
+	_, _ = fmt.Fprintf(mw.w, " %s\n", formatMetricValue(value))
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type routeKey struct {
+	Route  string
+	Method string
+}
+
+type requestKey struct {
+	routeKey
+	Code int
+}
+
+type latencyHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewMetrics creates a new Metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: make(map[requestKey]uint64),
+		latency:  make(map[routeKey]*latencyHistogram),
+	}
+}
+
+// Metrics collects HTTP request metrics for the API.
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[routeKey]*latencyHistogram
+}
+
+// Observe records a completed request.
+func (m *Metrics) Observe(route, method string, code int, seconds float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rk := routeKey{Route: route, Method: method}
+	m.requests[requestKey{routeKey: rk, Code: code}]++
+
+	h, ok := m.latency[rk]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(metricsLatencyBuckets))}
+		m.latency[rk] = h
+	}
+
+	for i, le := range metricsLatencyBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+
+	h.count++
+	h.sum += seconds
+}
+
+// write writes the collected HTTP metrics.
+func (m *Metrics) write(mw *metricWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Code < b.Code
+	})
+
+	mw.family("storm_http_requests_total", "counter", "Total number of HTTP requests by route, method and status code.")
+	for _, k := range requestKeys {
+		mw.sample("storm_http_requests_total", float64(m.requests[k]),
+			metricLabel{"route", k.Route},
+			metricLabel{"method", k.Method},
+			metricLabel{"code", strconv.Itoa(k.Code)},
+		)
+	}
+
+	routeKeys := make([]routeKey, 0, len(m.latency))
+	for k := range m.latency {
+		routeKeys = append(routeKeys, k)
+	}
+	sort.Slice(routeKeys, func(i, j int) bool {
+		a, b := routeKeys[i], routeKeys[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		return a.Method < b.Method
+	})
+
+	mw.family("storm_http_request_duration_seconds", "histogram", "HTTP request latency by route and method.")
+	for _, k := range routeKeys {
+		var (
+			h      = m.latency[k]
+			route  = metricLabel{"route", k.Route}
+			method = metricLabel{"method", k.Method}
+		)
+
+		for i, le := range metricsLatencyBuckets {
+			mw.sample("storm_http_request_duration_seconds_bucket", float64(h.buckets[i]), route, method, metricLabel{"le", formatMetricValue(le)})
+		}
+
+		mw.sample("storm_http_request_duration_seconds_bucket", float64(h.count), route, method, metricLabel{"le", "+Inf"})
+		mw.sample("storm_http_request_duration_seconds_sum", h.sum, route, method)
+		mw.sample("storm_http_request_duration_seconds_count", float64(h.count), route, method)
+	}
+}
+
+// daemonMetrics is the state of a single Deluge daemon at the time of scraping.
+type daemonMetrics struct {
+	name     string
+	pool     PoolStats
+	err      error
+	session  *deluge.SessionStatus
+	torrents map[string]*deluge.TorrentStatus
+	labels   map[string]string
+	diskFree int64
+}
+
+func collectDaemonMetrics(ctx context.Context, name string, pool *ConnectionPool) *daemonMetrics {
+	m := &daemonMetrics{name: name}
+	m.pool, _ = pool.Stats()
+
+	m.err = pool.Do(ctx, func(conn deluge.DelugeClient) (err error) {
+		m.session, err = conn.GetSessionStatus()
+		if err != nil {
+			return
+		}
+
+		m.torrents, err = conn.TorrentsStatus(deluge.StateUnspecified, nil)
+		if err != nil {
+			return
+		}
+
+		m.diskFree, err = conn.GetFreeSpace("")
+		if err != nil {
+			return
+		}
+
+		// The label plugin is optional
+		plugin, err := labelPluginClient(conn)
+		if err == nil {
+			m.labels, _ = plugin.GetTorrentsLabels(deluge.StateUnspecified, nil)
+		}
+
+		return nil
+	})
+
+	return m
+}
+
+type labelTotals struct {
+	torrents     int
+	size         int64
+	uploadRate   int64
+	downloadRate int64
+}
+
+func writeDaemonMetrics(mw *metricWriter, daemons []*daemonMetrics) {
+	mw.family("storm_pool_connections_in_flight", "gauge", "Deluge RPC connections currently in use.")
+	for _, d := range daemons {
+		mw.sample("storm_pool_connections_in_flight", float64(d.pool.InFlight), metricLabel{"daemon", d.name})
+	}
+
+	mw.family("storm_pool_connections_idle", "gauge", "Deluge RPC connections idle within the pool.")
+	for _, d := range daemons {
+		mw.sample("storm_pool_connections_idle", float64(d.pool.Idle), metricLabel{"daemon", d.name})
+	}
+
+	mw.family("storm_pool_waiters", "gauge", "Callers waiting for a Deluge RPC connection.")
+	for _, d := range daemons {
+		mw.sample("storm_pool_waiters", float64(d.pool.Waiting), metricLabel{"daemon", d.name})
+	}
+
+	mw.family("storm_pool_connect_failures_total", "counter", "Failed attempts to connect to the Deluge daemon.")
+	for _, d := range daemons {
+		mw.sample("storm_pool_connect_failures_total", float64(d.pool.ConnectFailures), metricLabel{"daemon", d.name})
+	}
+
+	mw.family("storm_deluge_up", "gauge", "Whether the Deluge daemon could be scraped.")
+	for _, d := range daemons {
+		var up float64
+		if d.err == nil {
+			up = 1
+		}
+
+		mw.sample("storm_deluge_up", up, metricLabel{"daemon", d.name})
+	}
+
+	var reachable []*daemonMetrics
+	for _, d := range daemons {
+		if d.err == nil {
+			reachable = append(reachable, d)
+		}
+	}
+
+	sessionGauges := []struct {
+		name, typ, help string
+		value           func(s *deluge.SessionStatus) float64
+	}{
+		{"storm_deluge_upload_rate_bytes", "gauge", "Total upload rate in bytes per second.", func(s *deluge.SessionStatus) float64 { return float64(s.UploadRate) }},
+		{"storm_deluge_download_rate_bytes", "gauge", "Total download rate in bytes per second.", func(s *deluge.SessionStatus) float64 { return float64(s.DownloadRate) }},
+		{"storm_deluge_payload_upload_rate_bytes", "gauge", "Payload upload rate in bytes per second.", func(s *deluge.SessionStatus) float64 { return float64(s.PayloadUploadRate) }},
+		{"storm_deluge_payload_download_rate_bytes", "gauge", "Payload download rate in bytes per second.", func(s *deluge.SessionStatus) float64 { return float64(s.PayloadDownloadRate) }},
+		{"storm_deluge_uploaded_bytes_total", "counter", "Total bytes uploaded this session.", func(s *deluge.SessionStatus) float64 { return float64(s.TotalUpload) }},
+		{"storm_deluge_downloaded_bytes_total", "counter", "Total bytes downloaded this session.", func(s *deluge.SessionStatus) float64 { return float64(s.TotalDownload) }},
+		{"storm_deluge_peers", "gauge", "Number of connected peers.", func(s *deluge.SessionStatus) float64 { return float64(s.NumPeers) }},
+		{"storm_deluge_dht_nodes", "gauge", "Number of DHT nodes.", func(s *deluge.SessionStatus) float64 { return float64(s.DhtNodes) }},
+	}
+
+	for _, g := range sessionGauges {
+		mw.family(g.name, g.typ, g.help)
+		for _, d := range reachable {
+			mw.sample(g.name, g.value(d.session), metricLabel{"daemon", d.name})
+		}
+	}
+
+	mw.family("storm_deluge_disk_free_bytes", "gauge", "Free space in the default download location.")
+	for _, d := range reachable {
+		mw.sample("storm_deluge_disk_free_bytes", float64(d.diskFree), metricLabel{"daemon", d.name})
+	}
+
+	mw.family("storm_deluge_torrents", "gauge", "Number of torrents by state.")
+	for _, d := range reachable {
+		states := make(map[string]int)
+		for _, t := range d.torrents {
+			states[t.State]++
+		}
+
+		stateNames := make([]string, 0, len(states))
+		for state := range states {
+			stateNames = append(stateNames, state)
+		}
+		sort.Strings(stateNames)
+
+		for _, state := range stateNames {
+			mw.sample("storm_deluge_torrents", float64(states[state]), metricLabel{"daemon", d.name}, metricLabel{"state", state})
+		}
+	}
+
+	type labelRow struct {
+		daemon, label string
+		totals        *labelTotals
+	}
+
+	var rows []labelRow
+	for _, d := range reachable {
+		labels := make(map[string]*labelTotals)
+		for hash, t := range d.torrents {
+			label := d.labels[hash]
+
+			totals, ok := labels[label]
+			if !ok {
+				totals = new(labelTotals)
+				labels[label] = totals
+			}
+
+			totals.torrents++
+			totals.size += t.TotalSize
+			totals.uploadRate += t.UploadPayloadRate
+			totals.downloadRate += t.DownloadPayloadRate
+		}
+
+		labelNames := make([]string, 0, len(labels))
+		for label := range labels {
+			labelNames = append(labelNames, label)
+		}
+		sort.Strings(labelNames)
+
+		for _, label := range labelNames {
+			rows = append(rows, labelRow{daemon: d.name, label: label, totals: labels[label]})
+		}
+	}
+
+	labelGauges := []struct {
+		name, help string
+		value      func(t *labelTotals) float64
+	}{
+		{"storm_deluge_label_torrents", "Number of torrents by label.", func(t *labelTotals) float64 { return float64(t.torrents) }},
+		{"storm_deluge_label_size_bytes", "Total size of torrents by label.", func(t *labelTotals) float64 { return float64(t.size) }},
+		{"storm_deluge_label_upload_rate_bytes", "Payload upload rate of torrents by label.", func(t *labelTotals) float64 { return float64(t.uploadRate) }},
+		{"storm_deluge_label_download_rate_bytes", "Payload download rate of torrents by label.", func(t *labelTotals) float64 { return float64(t.downloadRate) }},
+	}
+
+	for _, g := range labelGauges {
+		mw.family(g.name, "gauge", g.help)
+		for _, row := range rows {
+			mw.sample(g.name, g.value(row.totals), metricLabel{"daemon", row.daemon}, metricLabel{"label", row.label})
+		}
+	}
+}
+
+func (api *Api) httpMiddlewareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		wr := WrapResponse(rw)
+
+		next.ServeHTTP(wr, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		api.metrics.Observe(route, r.Method, wr.Code(), wr.Duration().Seconds())
+	})
+}
+
+// httpMetrics serves Storm and Deluge metrics in the Prometheus text exposition format.
+func (api *Api) httpMetrics(rw http.ResponseWriter, r *http.Request) {
+	var (
+		names   = api.daemons.Names()
+		daemons = make([]*daemonMetrics, len(names))
+		wg      sync.WaitGroup
+	)
+
+	for i, name := range names {
+		pool, err := api.daemons.Get(name)
+		if err != nil {
+			SendError(rw, err)
+			return
+		}
+
+		wg.Add(1)
+		go func(i int, name string, pool *ConnectionPool) {
+			defer wg.Done()
+			daemons[i] = collectDaemonMetrics(r.Context(), name, pool)
+		}(i, name, pool)
+	}
+
+	wg.Wait()
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+
+	mw := &metricWriter{w: rw}
+	api.metrics.write(mw)
+	writeDaemonMetrics(mw, daemons)
+}
@@ -0,0 +1,317 @@
+package storm
+
+import (
+	"context"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MoveStatusMoving   = "moving"
+	MoveStatusComplete = "complete"
+	MoveStatusFailed   = "failed"
+
+	// moveWatchInterval is how often the state of torrents being moved is checked.
+	moveWatchInterval = time.Second
+	// moveStartGrace is how long a torrent has to start moving before the move is considered to have failed.
+	moveStartGrace = time.Second * 10
+	// moveJobLogSize is the number of move jobs that are kept.
+	moveJobLogSize = 100
+)
+
+// MoveTorrentStatus is the progress of moving a single torrent.
+type MoveTorrentStatus struct {
+	State    string
+	SavePath string
+	Done     bool
+	Error    string `json:",omitempty"`
+}
+
+// MoveJob tracks moving the storage of one or more torrents to a new destination.
+type MoveJob struct {
+	ID          string
+	Daemon      string
+	Destination string
+	Status      string
+	Started     time.Time
+	Finished    *time.Time
+	Torrents    map[string]*MoveTorrentStatus
+}
+
+func (job *MoveJob) copy() *MoveJob {
+	c := *job
+	c.Torrents = make(map[string]*MoveTorrentStatus, len(job.Torrents))
+	for id, t := range job.Torrents {
+		ct := *t
+		c.Torrents[id] = &ct
+	}
+
+	return &c
+}
+
+// NewMoves creates a new Moves.
+// Torrents can only be moved to a destination within one of roots.
+func NewMoves(log *zap.Logger, roots []string) *Moves {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m := &Moves{
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for _, root := range roots {
+		m.roots = append(m.roots, path.Clean(root))
+	}
+
+	return m
+}
+
+// Moves starts moving torrents and watches them until they have finished moving.
+type Moves struct {
+	log   *zap.Logger
+	roots []string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs []*MoveJob
+}
+
+// Close stops watching any move in progress.
+func (m *Moves) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Destination validates and cleans a move destination.
+// The destination is a path on the Deluge daemon host and must be within one of the allowed roots.
+func (m *Moves) Destination(dest string) (string, error) {
+	if len(m.roots) == 0 {
+		return "", &Error{Code: http.StatusForbidden, Message: "Moving torrents is not enabled, no move roots are configured"}
+	}
+
+	if !path.IsAbs(dest) {
+		return "", &Error{Code: http.StatusBadRequest, Message: "Destination must be an absolute path"}
+	}
+
+	dest = path.Clean(dest)
+	for _, root := range m.roots {
+		if dest == root || strings.HasPrefix(dest, strings.TrimSuffix(root, "/")+"/") {
+			return dest, nil
+		}
+	}
+
+	return "", &Error{Code: http.StatusForbidden, Message: fmt.Sprintf("Destination %s is not within an allowed move root", dest)}
+}
+
+// Get gets a move job by ID.
+func (m *Moves) Get(id string) (*MoveJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return job.copy(), nil
+		}
+	}
+
+	return nil, &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Move %q does not exist", id)}
+}
+
+// List returns the most recent move jobs, newest first.
+func (m *Moves) List() []*MoveJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]*MoveJob, 0, len(m.jobs))
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, m.jobs[i].copy())
+	}
+
+	return jobs
+}
+
+// Start moves the storage of torrents on a daemon to dest and watches them until they have been moved.
+// dest must have been validated by Destination.
+func (m *Moves) Start(ctx context.Context, daemon string, pool *ConnectionPool, ids []string, dest string) (*MoveJob, error) {
+	err := pool.Do(ctx, func(conn deluge.DelugeClient) error {
+		return conn.MoveStorage(ids, dest)
+	})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	job := &MoveJob{
+		ID:          randomID()[:16],
+		Daemon:      daemon,
+		Destination: dest,
+		Status:      MoveStatusMoving,
+		Started:     time.Now().UTC(),
+		Torrents:    make(map[string]*MoveTorrentStatus, len(ids)),
+	}
+
+	for _, id := range ids {
+		job.Torrents[id] = &MoveTorrentStatus{State: string(deluge.StateMoving)}
+	}
+
+	m.mu.Lock()
+	m.jobs = append(m.jobs, job)
+	if len(m.jobs) > moveJobLogSize {
+		m.jobs = m.jobs[len(m.jobs)-moveJobLogSize:]
+	}
+	copied := job.copy()
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.watch(job, pool, ids)
+
+	return copied, nil
+}
+
+// watch polls the status of torrents being moved until every torrent has either moved or failed.
+func (m *Moves) watch(job *MoveJob, pool *ConnectionPool, ids []string) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(moveWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var statuses map[string]*deluge.TorrentStatus
+		err := pool.Do(m.ctx, func(conn deluge.DelugeClient) (err error) {
+			statuses, err = conn.TorrentsStatus(deluge.StateUnspecified, ids)
+			return
+		})
+		if err != nil {
+			m.log.Error("Failed to check the progress of moving torrents", zap.String("Move", job.ID), zap.Error(err))
+			continue
+		}
+
+		if m.update(job, statuses) {
+			return
+		}
+	}
+}
+
+// update updates the progress of a job and returns true if the job has finished.
+func (m *Moves) update(job *MoveJob, statuses map[string]*deluge.TorrentStatus) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		now      = time.Now().UTC()
+		finished = true
+		failed   = false
+	)
+
+	for id, t := range job.Torrents {
+		if t.Done {
+			failed = failed || t.Error != ""
+			continue
+		}
+
+		status, ok := statuses[id]
+		switch {
+		case !ok:
+			t.Done, t.Error = true, "Torrent no longer exists"
+		case status.State == string(deluge.StateMoving):
+			t.State, t.SavePath = status.State, status.SavePath
+		case status.State == string(deluge.StateError):
+			t.State, t.SavePath = status.State, status.SavePath
+			t.Done, t.Error = true, "Torrent is in the Error state"
+		case path.Clean(status.SavePath) == job.Destination:
+			t.State, t.SavePath = status.State, status.SavePath
+			t.Done = true
+		case now.Sub(job.Started) > moveStartGrace:
+			t.State, t.SavePath = status.State, status.SavePath
+			t.Done, t.Error = true, "Torrent was not moved"
+		}
+
+		finished = finished && t.Done
+		failed = failed || t.Error != ""
+	}
+
+	if !finished {
+		return false
+	}
+
+	job.Finished = &now
+	job.Status = MoveStatusComplete
+	if failed {
+		job.Status = MoveStatusFailed
+	}
+
+	m.log.Info("Finished moving torrents", zap.String("Move", job.ID), zap.String("Destination", job.Destination), zap.String("Status", job.Status))
+	return true
+}
+
+type MoveTorrentsRequest struct {
+	Destination string
+}
+
+// startMove starts moving torrents on the daemon selected by the request
+func (api *Api) startMove(r *http.Request, ids []string) (interface{}, error) {
+	var req MoveTorrentsRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := api.moves.Destination(req.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	name := mux.Vars(r)["daemon"]
+	if name == "" {
+		name = api.daemons.Default()
+	}
+
+	pool, err := api.daemons.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.moves.Start(r.Context(), name, pool, ids, dest)
+}
+
+// httpMoveTorrents moves the storage of the torrents given in the id query
+func (api *Api) httpMoveTorrents(r *http.Request) (interface{}, error) {
+	ids, err := torrentIDs(r.URL.Query(), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.startMove(r, ids)
+}
+
+// httpMoveTorrent moves the storage of a single torrent
+func (api *Api) httpMoveTorrent(r *http.Request) (interface{}, error) {
+	return api.startMove(r, []string{mux.Vars(r)["id"]})
+}
+
+// httpMoves lists the most recent moves
+func (api *Api) httpMoves(_ *http.Request) (interface{}, error) {
+	return api.moves.List(), nil
+}
+
+// httpMove gets the progress of a move
+func (api *Api) httpMove(r *http.Request) (interface{}, error) {
+	return api.moves.Get(mux.Vars(r)["id"])
+}
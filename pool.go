@@ -27,6 +27,18 @@ func (req *poolReq) Send(conn deluge.DelugeClient) bool {
 	}
 }
 
+// PoolStats is a snapshot of the state of a ConnectionPool.
+type PoolStats struct {
+	// InFlight is the number of connections currently in use
+	InFlight int
+	// Idle is the number of connections idle within the pool
+	Idle int
+	// Waiting is the number of callers waiting for a connection
+	Waiting int
+	// ConnectFailures is the total number of failed attempts to connect to the Deluge daemon
+	ConnectFailures uint64
+}
+
 type idleConnection struct {
 	idle time.Time
 	conn deluge.DelugeClient
@@ -66,6 +78,7 @@ func NewConnectionPool(log *zap.Logger, maxConnections int, idleConnectionTime t
 
 		get:   make(chan *poolReq),
 		put:   make(chan deluge.DelugeClient),
+		stats: make(chan chan PoolStats),
 		close: make(chan struct{}),
 		alive: new(sync.Mutex),
 		idle:  nullTimer{},
@@ -83,13 +96,15 @@ type ConnectionPool struct {
 
 	get   chan *poolReq
 	put   chan deluge.DelugeClient
+	stats chan chan PoolStats
 	close chan struct{}
 	alive *sync.Mutex
 
-	waitConn []*poolReq
-	inFlight int
-	pool     []*idleConnection
-	idle     Timer
+	waitConn        []*poolReq
+	inFlight        int
+	pool            []*idleConnection
+	idle            Timer
+	connectFailures uint64
 }
 
 func (pool *ConnectionPool) nextIdle() {
@@ -215,6 +230,7 @@ func (pool *ConnectionPool) getConn(req *poolReq) {
 	err := conn.Connect()
 	if err != nil {
 		pool.Log.Error("Failed to establish Deluge RPC connection", zap.Error(err))
+		pool.connectFailures++
 		conn = nil
 	}
 
@@ -249,6 +265,13 @@ func (pool *ConnectionPool) worker() {
 			pool.getConn(req)
 		case conn := <-pool.put: // A connection has been put back
 			pool.putConn(conn)
+		case reply := <-pool.stats:
+			reply <- PoolStats{
+				InFlight:        pool.inFlight,
+				Idle:            len(pool.pool),
+				Waiting:         len(pool.waitConn),
+				ConnectFailures: pool.connectFailures,
+			}
 		case <-pool.close:
 			pool.closeConns()
 			return
@@ -289,6 +312,32 @@ func (pool *ConnectionPool) Put(conn deluge.DelugeClient) {
 	}
 }
 
+// Do gets a connection from the pool and calls f with that connection.
+// The connection is put back to the pool when f returns.
+func (pool *ConnectionPool) Do(ctx context.Context, f func(conn deluge.DelugeClient) error) error {
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = f(conn)
+	pool.Put(conn)
+
+	return err
+}
+
+// Stats gets a snapshot of the current state of the pool.
+func (pool *ConnectionPool) Stats() (PoolStats, error) {
+	replyCh := make(chan PoolStats, 1)
+
+	select {
+	case <-pool.close:
+		return PoolStats{}, errors.New("The Deluge RPC connection pool has been closed")
+	case pool.stats <- replyCh:
+		return <-replyCh, nil
+	}
+}
+
 func (pool *ConnectionPool) Close() {
 	close(pool.close)
 
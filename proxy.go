@@ -0,0 +1,72 @@
+package storm
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is a list of networks that reverse proxies in front of Storm connect from.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of CIDRs or single IP addresses.
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// Contains returns true if ip is a trusted proxy.
+func (t TrustedProxies) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the IP address of the peer that made the request.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// FromTrustedProxy returns true if the request was made by a trusted proxy.
+func (t TrustedProxies) FromTrustedProxy(r *http.Request) bool {
+	return t.Contains(remoteIP(r))
+}
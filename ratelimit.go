@@ -0,0 +1,341 @@
+package storm
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientIP returns the IP address of the client that made the request.
+// X-Forwarded-For is only used if the request was made by a trusted proxy,
+// in which case the client is the last address in the header that is not a trusted proxy.
+func (t TrustedProxies) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if !t.Contains(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !t.Contains(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// clientKey returns the key used to track the client that made the request.
+func (api *Api) clientKey(r *http.Request) string {
+	ip := api.trustedProxies.ClientIP(r)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+
+	return ip.String()
+}
+
+// sendRetryAfter sends err with a Retry-After header.
+func sendRetryAfter(rw http.ResponseWriter, err error, after time.Duration) {
+	rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+	SendError(rw, err)
+}
+
+// LockoutConfig configures locking out clients after repeated failed authentication attempts.
+type LockoutConfig struct {
+	// Attempts is the number of failed attempts allowed before a client is locked out.
+	// Lockout is disabled if zero.
+	Attempts int
+	// Duration is how long a client is locked out for the first time, doubling for each further failed attempt
+	Duration time.Duration
+	// MaxDuration is the longest a client can be locked out for
+	MaxDuration time.Duration
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewLockout creates a new Lockout.
+func NewLockout(config LockoutConfig) *Lockout {
+	if config.Duration <= 0 {
+		config.Duration = time.Minute
+	}
+	if config.MaxDuration < config.Duration {
+		config.MaxDuration = config.Duration
+	}
+
+	return &Lockout{
+		config:  config,
+		clients: make(map[string]*lockoutEntry),
+	}
+}
+
+// Lockout tracks failed authentication attempts for each client and locks out clients with too many failures.
+type Lockout struct {
+	config LockoutConfig
+
+	mu      sync.Mutex
+	clients map[string]*lockoutEntry
+}
+
+// prune forgets clients that have not failed for MaxDuration. The caller must hold the lock.
+func (l *Lockout) prune(now time.Time) {
+	for key, e := range l.clients {
+		if now.Sub(e.lastFailure) > l.config.MaxDuration && !now.Before(e.lockedUntil) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Locked returns how much longer the client is locked out for, or zero if it is not locked out.
+func (l *Lockout) Locked(key string) time.Duration {
+	if l.config.Attempts == 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.clients[key]
+	if !ok {
+		return 0
+	}
+
+	if remaining := time.Until(e.lockedUntil); remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// Fail records a failed attempt by the client and returns how long it is now locked out for, if at all.
+func (l *Lockout) Fail(key string) (int, time.Duration) {
+	if l.config.Attempts == 0 {
+		return 0, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	e, ok := l.clients[key]
+	if !ok {
+		l.prune(now)
+		e = new(lockoutEntry)
+		l.clients[key] = e
+	}
+
+	// Start counting again once the client has gone long enough without failing
+	if now.Sub(e.lastFailure) > l.config.MaxDuration {
+		e.failures = 0
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures < l.config.Attempts {
+		return e.failures, 0
+	}
+
+	// Stop doubling once the next doubling would exceed MaxDuration so that it cannot overflow
+	duration := l.config.Duration
+	for i := l.config.Attempts; i < e.failures; i++ {
+		if duration > l.config.MaxDuration/2 {
+			duration = l.config.MaxDuration
+			break
+		}
+		duration *= 2
+	}
+
+	e.lockedUntil = now.Add(duration)
+	return e.failures, duration
+}
+
+// Succeed forgets the failed attempts of the client.
+func (l *Lockout) Succeed(key string) {
+	if l.config.Attempts == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.clients, key)
+}
+
+// lockoutError is returned instead of checking the credentials of a client that is locked out.
+type lockoutError struct {
+	remaining time.Duration
+}
+
+func (e *lockoutError) Error() string {
+	return "Too many failed authentication attempts, try again later"
+}
+
+func (e *lockoutError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+// lockedOut returns a lockoutError if the client making the request is locked out.
+// Only requests that check a password are refused, so a locked out client can still use an existing session or token.
+func (api *Api) lockedOut(r *http.Request) *lockoutError {
+	remaining := api.lockout.Locked(api.clientKey(r))
+	if remaining == 0 {
+		return nil
+	}
+
+	return &lockoutError{remaining: remaining}
+}
+
+// sendAuthError sends an authentication error, with a Retry-After header if the client is locked out.
+func sendAuthError(rw http.ResponseWriter, err error) {
+	if locked, ok := err.(*lockoutError); ok {
+		sendRetryAfter(rw, locked, locked.remaining)
+		return
+	}
+
+	SendError(rw, err)
+}
+
+// recordAuthentication records the outcome of an authentication attempt by the client making the request.
+func (api *Api) recordAuthentication(r *http.Request, username string, err error) {
+	key := api.clientKey(r)
+
+	if err == nil {
+		api.lockout.Succeed(key)
+		return
+	}
+
+	httpError, ok := err.(HTTPError)
+	if !ok || httpError.StatusCode() != http.StatusUnauthorized {
+		return
+	}
+
+	failures, locked := api.lockout.Fail(key)
+
+	api.log.Warn("Authentication failed",
+		zap.String("Client", key),
+		zap.String("Username", username),
+		zap.Int("Failures", failures),
+	)
+
+	if locked > 0 {
+		api.log.Warn("Client locked out after failed authentication attempts",
+			zap.String("Client", key),
+			zap.Int("Failures", failures),
+			zap.Duration("Duration", locked),
+		)
+	}
+}
+
+// RateLimitConfig configures the per-client request rate limit.
+type RateLimitConfig struct {
+	// Rate is the number of requests per second allowed on average. Rate limiting is disabled if zero.
+	Rate float64
+	// Burst is the number of requests that can be made at once
+	Burst int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a new RateLimiter.
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+
+	return &RateLimiter{
+		config:  config,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// RateLimiter limits the rate of requests made by each client using a token bucket.
+type RateLimiter struct {
+	config RateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// prune forgets clients whose bucket has refilled. The caller must hold the lock.
+func (l *RateLimiter) prune(now time.Time) {
+	full := time.Duration(float64(l.config.Burst) / l.config.Rate * float64(time.Second))
+	if now.Sub(l.pruned) < full {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.pruned = now
+}
+
+// Allow takes a token from the bucket of the client.
+// If the bucket is empty then it returns how long until a token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.config.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.config.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.config.Burst), b.tokens+now.Sub(b.last).Seconds()*l.config.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.config.Rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// httpMiddlewareRateLimit rejects requests from clients that exceed the rate limit.
+func (api *Api) httpMiddlewareRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ok, wait := api.rateLimiter.Allow(api.clientKey(r))
+		if !ok {
+			sendRetryAfter(rw, &Error{
+				Code:    http.StatusTooManyRequests,
+				Message: fmt.Sprintf("Rate limit exceeded, try again in %s", wait.Round(time.Millisecond)),
+			}, wait)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
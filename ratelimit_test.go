@@ -0,0 +1,110 @@
+package storm
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(LockoutConfig{Attempts: 3, Duration: time.Minute, MaxDuration: 10 * time.Minute})
+
+	want := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, duration := range want {
+		failures, locked := l.Fail("client")
+		if failures != i+1 || locked != duration {
+			t.Errorf("Fail() = %d, %s, want %d, %s", failures, locked, i+1, duration)
+		}
+	}
+
+	if remaining := l.Locked("client"); remaining <= 9*time.Minute || remaining > 10*time.Minute {
+		t.Errorf("Locked() = %s, want about 10m", remaining)
+	}
+	if remaining := l.Locked("other"); remaining != 0 {
+		t.Errorf("Locked() of another client = %s, want 0", remaining)
+	}
+
+	l.Succeed("client")
+
+	if remaining := l.Locked("client"); remaining != 0 {
+		t.Errorf("Locked() after Succeed() = %s, want 0", remaining)
+	}
+	if failures, locked := l.Fail("client"); failures != 1 || locked != 0 {
+		t.Errorf("Fail() after Succeed() = %d, %s, want 1, 0", failures, locked)
+	}
+}
+
+func TestLockoutManyFailures(t *testing.T) {
+	// Doubling the duration this many times would overflow
+	l := NewLockout(LockoutConfig{Attempts: 1, Duration: time.Hour, MaxDuration: math.MaxInt64})
+
+	var previous time.Duration
+	for i := 0; i < 200; i++ {
+		_, locked := l.Fail("client")
+		if locked < previous {
+			t.Fatalf("Fail() after %d failures = %s, want at least %s", i+1, locked, previous)
+		}
+
+		previous = locked
+	}
+
+	if previous != math.MaxInt64 {
+		t.Errorf("Fail() = %s, want the maximum duration", previous)
+	}
+}
+
+func TestLockoutDisabled(t *testing.T) {
+	l := NewLockout(LockoutConfig{})
+
+	for i := 0; i < 10; i++ {
+		if failures, locked := l.Fail("client"); failures != 0 || locked != 0 {
+			t.Fatalf("Fail() = %d, %s, want 0, 0", failures, locked)
+		}
+	}
+
+	if remaining := l.Locked("client"); remaining != 0 {
+		t.Errorf("Locked() = %s, want 0", remaining)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{Rate: 1, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("client"); !ok {
+			t.Fatalf("Allow() request %d was rejected within the burst", i+1)
+		}
+	}
+
+	ok, wait := l.Allow("client")
+	if ok || wait <= 0 || wait > time.Second {
+		t.Errorf("Allow() after the burst = %t, %s, want false with a wait of at most 1s", ok, wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := l.Allow("other"); !ok {
+		t.Error("Allow() of another client was rejected")
+	}
+
+	// The bucket refills at Rate tokens per second, up to Burst
+	l.buckets["client"].last = time.Now().Add(-time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("client"); !ok {
+			t.Fatalf("Allow() request %d after refilling was rejected", i+1)
+		}
+	}
+	if ok, _ := l.Allow("client"); ok {
+		t.Error("Allow() after refilling allowed more than the burst")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{})
+
+	for i := 0; i < 100; i++ {
+		if ok, wait := l.Allow("client"); !ok || wait != 0 {
+			t.Fatalf("Allow() = %t, %s, want true, 0", ok, wait)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 )
 
 var _ http.ResponseWriter = (*WrappedResponse)(nil)
+var _ http.Flusher = (*WrappedResponse)(nil)
 
 // WrapResponse wraps a response
 func WrapResponse(rw http.ResponseWriter) *WrappedResponse {
@@ -47,6 +48,13 @@ func (rw *WrappedResponse) Write(b []byte) (int, error) {
 	return wr, err
 }
 
+// Flush flushes the underlying response if it supports flushing.
+func (rw *WrappedResponse) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (rw *WrappedResponse) Code() int {
 	return rw.code
 }
@@ -0,0 +1,819 @@
+package storm
+
+import (
+	"context"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RuleActionPause  = "pause"
+	RuleActionRemove = "remove"
+
+	// DefaultRuleInterval is how often rules are evaluated if not configured.
+	DefaultRuleInterval = time.Minute * 5
+	// ruleAuditLogSize is the number of actions kept in the audit log.
+	ruleAuditLogSize = 500
+)
+
+// ruleCondition compares a single field of a torrent to a value.
+type ruleCondition struct {
+	field string
+	op    string
+	str   string
+	num   float64
+	re    *regexp.Regexp
+}
+
+// ruleExpression is a list of alternatives (OR) each of which is a list of conditions (AND).
+type ruleExpression [][]*ruleCondition
+
+var (
+	// The separators also match at either end so that a missing condition leaves an empty term
+	ruleOrSeparator  = regexp.MustCompile(`(?i)(?:^|\s+)OR(?:\s+|$)`)
+	ruleAndSeparator = regexp.MustCompile(`(?i)(?:^|\s+)AND(?:\s+|$)`)
+	ruleConditionRe  = regexp.MustCompile(`^\s*([a-z_]+)\s*(>=|<=|!=|=~|==|=|>|<)\s*(.*?)\s*$`)
+
+	ruleStringFields   = map[string]bool{"name": true, "label": true, "tracker": true, "state": true}
+	ruleNumberFields   = map[string]bool{"ratio": true, "progress": true, "size": true}
+	ruleDurationFields = map[string]bool{"seeding_time": true, "active_time": true, "age": true}
+)
+
+// parseRuleDuration parses a duration such as 14d, 12h, 30m or a number of seconds.
+func parseRuleDuration(s string) (float64, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+
+		return n * 24 * 60 * 60, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.Seconds(), nil
+}
+
+// parseRuleSize parses a size in bytes with an optional binary unit such as 10G or 512MiB.
+func parseRuleSize(s string) (float64, error) {
+	var (
+		upper      = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+		multiplier = 1.0
+	)
+
+	if upper != "" {
+		if i := strings.IndexByte("KMGT", upper[len(upper)-1]); i >= 0 {
+			multiplier = float64(uint64(1) << (10 * (i + 1)))
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return n * multiplier, nil
+}
+
+// parseRuleExpression parses an expression such as "label=tv AND ratio>=2 OR seeding_time>14d".
+// AND binds more tightly than OR.
+func parseRuleExpression(s string) (ruleExpression, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+
+	var expr ruleExpression
+	for _, alternative := range ruleOrSeparator.Split(s, -1) {
+		var conditions []*ruleCondition
+		for _, term := range ruleAndSeparator.Split(alternative, -1) {
+			m := ruleConditionRe.FindStringSubmatch(term)
+			if m == nil {
+				return nil, fmt.Errorf("%q is not in the form field operator value", strings.TrimSpace(term))
+			}
+
+			// An empty value must be quoted, such as label=""
+			if m[3] == "" {
+				return nil, fmt.Errorf("%s: value is missing", strings.TrimSpace(term))
+			}
+
+			c := &ruleCondition{
+				field: m[1],
+				op:    m[2],
+				str:   strings.Trim(m[3], `"'`),
+			}
+			if c.op == "==" {
+				c.op = "="
+			}
+
+			var err error
+			switch {
+			case ruleStringFields[c.field]:
+				switch c.op {
+				case "=", "!=":
+				case "=~":
+					c.re, err = regexp.Compile(c.str)
+				default:
+					err = fmt.Errorf("operator %s cannot be used with %s", c.op, c.field)
+				}
+			case c.op == "=~":
+				err = fmt.Errorf("operator =~ cannot be used with %s", c.field)
+			case ruleNumberFields[c.field] && c.field == "size":
+				c.num, err = parseRuleSize(c.str)
+			case ruleNumberFields[c.field]:
+				c.num, err = strconv.ParseFloat(c.str, 64)
+			case ruleDurationFields[c.field]:
+				c.num, err = parseRuleDuration(c.str)
+			default:
+				err = fmt.Errorf("unknown field %q", c.field)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", strings.TrimSpace(term), err)
+			}
+
+			conditions = append(conditions, c)
+		}
+
+		expr = append(expr, conditions)
+	}
+
+	return expr, nil
+}
+
+// usesLabel returns true if the expression has a condition on the label of a torrent.
+func (expr ruleExpression) usesLabel() bool {
+	for _, conditions := range expr {
+		for _, c := range conditions {
+			if c.field == "label" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ruleTorrent is a torrent that rules are evaluated against.
+type ruleTorrent struct {
+	ID    string
+	Label string
+	*deluge.TorrentStatus
+}
+
+func (c *ruleCondition) value(t *ruleTorrent, now time.Time) (string, float64) {
+	switch c.field {
+	case "name":
+		return t.Name, 0
+	case "label":
+		return t.Label, 0
+	case "tracker":
+		return t.TrackerHost, 0
+	case "state":
+		return t.State, 0
+	case "ratio":
+		return "", float64(t.Ratio)
+	case "progress":
+		return "", float64(t.Progress)
+	case "size":
+		return "", float64(t.TotalSize)
+	case "seeding_time":
+		return "", float64(t.SeedingTime)
+	case "active_time":
+		return "", float64(t.ActiveTime)
+	case "age":
+		return "", now.Sub(time.Unix(int64(t.TimeAdded), 0)).Seconds()
+	}
+
+	return "", 0
+}
+
+func (c *ruleCondition) match(t *ruleTorrent, now time.Time) bool {
+	s, n := c.value(t, now)
+
+	if ruleStringFields[c.field] {
+		switch c.op {
+		case "=":
+			return strings.EqualFold(s, c.str)
+		case "!=":
+			return !strings.EqualFold(s, c.str)
+		case "=~":
+			return c.re.MatchString(s)
+		}
+
+		return false
+	}
+
+	switch c.op {
+	case "=":
+		return n == c.num
+	case "!=":
+		return n != c.num
+	case ">":
+		return n > c.num
+	case ">=":
+		return n >= c.num
+	case "<":
+		return n < c.num
+	case "<=":
+		return n <= c.num
+	}
+
+	return false
+}
+
+func (expr ruleExpression) match(t *ruleTorrent, now time.Time) bool {
+Alternatives:
+	for _, conditions := range expr {
+		for _, c := range conditions {
+			if !c.match(t, now) {
+				continue Alternatives
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Rule automatically pauses or removes torrents that match an expression.
+type Rule struct {
+	ID   string
+	Name string
+	// When is the expression that torrents must match, such as "label=tv AND ratio>=2 OR seeding_time>14d"
+	When   string
+	Action string
+	// RemoveFiles also deletes the downloaded data when the action is remove
+	RemoveFiles bool
+	// Daemon limits the rule to a single daemon. The rule applies to all daemons if empty.
+	Daemon  string
+	Enabled bool
+	// DryRun records the actions the rule would take without taking them
+	DryRun bool
+}
+
+type compiledRule struct {
+	Rule
+	expr ruleExpression
+}
+
+func compileRule(rule Rule) (*compiledRule, error) {
+	if rule.Name == "" {
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Rule Name must not be empty"}
+	}
+
+	switch rule.Action {
+	case RuleActionPause:
+		if rule.RemoveFiles {
+			return nil, &Error{Code: http.StatusBadRequest, Message: "RemoveFiles can only be used with the remove action"}
+		}
+	case RuleActionRemove:
+	default:
+		return nil, &Error{Code: http.StatusBadRequest, Message: "Rule Action must be one of pause or remove"}
+	}
+
+	expr, err := parseRuleExpression(rule.When)
+	if err != nil {
+		return nil, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Rule When is not valid: %s", err)}
+	}
+
+	return &compiledRule{Rule: rule, expr: expr}, nil
+}
+
+type rulesFile struct {
+	Rules []Rule
+}
+
+type ruleAuditFile struct {
+	Entries []*RuleAuditEntry
+}
+
+// ruleAuditPath returns the path of the audit log kept next to the rules file at path, such as rules-audit.json for rules.json.
+func ruleAuditPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-audit" + ext
+}
+
+// OpenRuleStore opens the file-backed rule store at path.
+// The file is created when the first rule is added.
+func OpenRuleStore(path string) (*RuleStore, error) {
+	var f rulesFile
+
+	err := loadJSON(path, &f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules from %s: %w", path, err)
+	}
+
+	store := &RuleStore{
+		path:      path,
+		auditPath: ruleAuditPath(path),
+	}
+
+	for _, rule := range f.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q in %s: %w", rule.Name, path, err)
+		}
+
+		store.rules = append(store.rules, compiled)
+	}
+
+	var audit ruleAuditFile
+
+	err = loadJSON(store.auditPath, &audit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rule audit log from %s: %w", store.auditPath, err)
+	}
+
+	store.audit = audit.Entries
+
+	return store, nil
+}
+
+// RuleStore is a file-backed, ordered list of rules along with the audit log of actions taken by them.
+type RuleStore struct {
+	path      string
+	auditPath string
+
+	mu    sync.RWMutex
+	rules []*compiledRule
+
+	auditMu sync.Mutex
+	audit   []*RuleAuditEntry
+}
+
+// save persists the store. The caller must hold the write lock.
+func (s *RuleStore) save() error {
+	f := rulesFile{
+		Rules: make([]Rule, 0, len(s.rules)),
+	}
+
+	for _, rule := range s.rules {
+		f.Rules = append(f.Rules, rule.Rule)
+	}
+
+	return saveJSON(s.path, &f)
+}
+
+func (s *RuleStore) compiled() []*compiledRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]*compiledRule, len(s.rules))
+	copy(rules, s.rules)
+
+	return rules
+}
+
+// List returns all rules in the order they are evaluated.
+func (s *RuleStore) List() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule.Rule)
+	}
+
+	return rules
+}
+
+// Create adds a new rule to the end of the list.
+func (s *RuleStore) Create(rule Rule) (Rule, error) {
+	rule.ID = randomID()[:16]
+
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = append(s.rules, compiled)
+
+	err = s.save()
+	if err != nil {
+		s.rules = s.rules[:len(s.rules)-1]
+		return Rule{}, err
+	}
+
+	return rule, nil
+}
+
+// Update replaces an existing rule.
+func (s *RuleStore) Update(id string, rule Rule) (Rule, error) {
+	rule.ID = id
+
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.rules {
+		if existing.ID != id {
+			continue
+		}
+
+		s.rules[i] = compiled
+
+		err = s.save()
+		if err != nil {
+			s.rules[i] = existing
+			return Rule{}, err
+		}
+
+		return rule, nil
+	}
+
+	return Rule{}, &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Rule %q does not exist", id)}
+}
+
+// Delete removes a rule.
+func (s *RuleStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.rules {
+		if existing.ID != id {
+			continue
+		}
+
+		previous := s.rules
+		s.rules = append(append([]*compiledRule(nil), s.rules[:i]...), s.rules[i+1:]...)
+
+		err := s.save()
+		if err != nil {
+			s.rules = previous
+		}
+
+		return err
+	}
+
+	return &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Rule %q does not exist", id)}
+}
+
+// Audit returns the most recent actions, newest first.
+func (s *RuleStore) Audit() []*RuleAuditEntry {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	entries := make([]*RuleAuditEntry, 0, len(s.audit))
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		entry := *s.audit[i]
+		entries = append(entries, &entry)
+	}
+
+	return entries
+}
+
+// record adds entries to the audit log and persists it.
+func (s *RuleStore) record(entries []*RuleAuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	s.audit = append(s.audit, entries...)
+	if len(s.audit) > ruleAuditLogSize {
+		s.audit = s.audit[len(s.audit)-ruleAuditLogSize:]
+	}
+
+	return saveJSON(s.auditPath, &ruleAuditFile{Entries: s.audit})
+}
+
+// RuleAuditEntry records an action taken, or that would have been taken, by a rule.
+type RuleAuditEntry struct {
+	Time    time.Time
+	Rule    string
+	RuleID  string
+	Daemon  string
+	Torrent string
+	Name    string
+	Action  string
+	DryRun  bool
+	Error   string `json:",omitempty"`
+}
+
+// RulesConfig configures the rules engine.
+type RulesConfig struct {
+	// Store enables the rules engine when set
+	Store *RuleStore
+	// Interval is how often rules are evaluated, defaults to DefaultRuleInterval
+	Interval time.Duration
+	// DryRun records the actions of every rule without taking them
+	DryRun bool
+}
+
+// NewRuleEngine creates a new RuleEngine. Call Start to begin evaluating rules periodically.
+func NewRuleEngine(log *zap.Logger, daemons *Daemons, config RulesConfig) *RuleEngine {
+	if config.Interval <= 0 {
+		config.Interval = DefaultRuleInterval
+	}
+
+	// Rules loaded from the file may refer to a daemon that is no longer configured, they are kept but never run
+	for _, rule := range config.Store.List() {
+		if rule.Daemon == "" {
+			continue
+		}
+
+		_, err := daemons.Get(rule.Daemon)
+		if err != nil {
+			log.Warn("Rule will not be run because its daemon does not exist",
+				zap.String("Rule", rule.Name),
+				zap.String("Daemon", rule.Daemon),
+			)
+		}
+	}
+
+	return &RuleEngine{
+		log:     log,
+		daemons: daemons,
+		config:  config,
+	}
+}
+
+// RuleEngine periodically evaluates rules against the torrents of every daemon.
+type RuleEngine struct {
+	log     *zap.Logger
+	daemons *Daemons
+	config  RulesConfig
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// run is held while rules are being evaluated
+	run sync.Mutex
+}
+
+// Start starts evaluating rules in the background.
+func (e *RuleEngine) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		ticker := time.NewTicker(e.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.Run(ctx, false)
+			}
+		}
+	}()
+}
+
+// Stop stops evaluating rules and waits for any evaluation in progress to finish.
+func (e *RuleEngine) Stop() {
+	if e.cancel == nil {
+		return
+	}
+
+	e.cancel()
+	e.wg.Wait()
+}
+
+// Audit returns the most recent actions, newest first.
+func (e *RuleEngine) Audit() []*RuleAuditEntry {
+	return e.config.Store.Audit()
+}
+
+// Run evaluates all enabled rules against the torrents of every daemon and takes their actions.
+// The actions are returned and recorded in the audit log.
+// If dryRun is set then the run is only a preview, no actions are taken and nothing is recorded.
+func (e *RuleEngine) Run(ctx context.Context, dryRun bool) []*RuleAuditEntry {
+	e.run.Lock()
+	defer e.run.Unlock()
+
+	var (
+		rules   = e.config.Store.compiled()
+		entries = make([]*RuleAuditEntry, 0)
+	)
+
+	for _, name := range e.daemons.Names() {
+		var daemonRules []*compiledRule
+		for _, rule := range rules {
+			if rule.Enabled && (rule.Daemon == "" || rule.Daemon == name) {
+				daemonRules = append(daemonRules, rule)
+			}
+		}
+
+		if len(daemonRules) == 0 {
+			continue
+		}
+
+		pool, err := e.daemons.Get(name)
+		if err != nil {
+			continue
+		}
+
+		err = pool.Do(ctx, func(conn deluge.DelugeClient) error {
+			daemonEntries, err := e.runDaemon(conn, name, daemonRules, dryRun || e.config.DryRun)
+			entries = append(entries, daemonEntries...)
+			return err
+		})
+		if err != nil {
+			e.log.Error("Failed to evaluate rules", zap.String("Daemon", name), zap.Error(err))
+		}
+	}
+
+	if dryRun {
+		return entries
+	}
+
+	err := e.config.Store.record(entries)
+	if err != nil {
+		e.log.Error("Failed to save rule audit log", zap.Error(err))
+	}
+
+	return entries
+}
+
+// runDaemon evaluates rules against the torrents of a single daemon.
+// Each torrent is acted on by the first rule it matches.
+func (e *RuleEngine) runDaemon(conn deluge.DelugeClient, daemon string, rules []*compiledRule, dryRun bool) ([]*RuleAuditEntry, error) {
+	statuses, err := conn.TorrentsStatus(deluge.StateUnspecified, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels map[string]string
+	for _, rule := range rules {
+		if !rule.expr.usesLabel() {
+			continue
+		}
+
+		plugin, err := labelPluginClient(conn)
+		if err == nil {
+			labels, err = plugin.GetTorrentsLabels(deluge.StateUnspecified, nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get torrent labels: %w", err)
+		}
+
+		break
+	}
+
+	var (
+		now     = time.Now()
+		entries []*RuleAuditEntry
+	)
+
+	for _, id := range sortedIDs(statuses) {
+		t := &ruleTorrent{ID: id, Label: labels[id], TorrentStatus: statuses[id]}
+
+		for _, rule := range rules {
+			if !rule.expr.match(t, now) {
+				continue
+			}
+
+			// Paused torrents have already had the pause action taken
+			if rule.Action == RuleActionPause && t.State == string(deluge.StatePaused) {
+				break
+			}
+
+			entry := &RuleAuditEntry{
+				Time:    now.UTC(),
+				Rule:    rule.Name,
+				RuleID:  rule.ID,
+				Daemon:  daemon,
+				Torrent: id,
+				Name:    t.Name,
+				Action:  rule.Action,
+				DryRun:  dryRun || rule.DryRun,
+			}
+
+			if rule.Action == RuleActionRemove && rule.RemoveFiles {
+				entry.Action = "remove with files"
+			}
+
+			if !entry.DryRun {
+				err = e.act(conn, rule, id)
+				if err != nil {
+					entry.Error = rpcError(err).Error()
+				}
+			}
+
+			e.log.Info("Rule matched torrent",
+				zap.String("Rule", rule.Name),
+				zap.String("Daemon", daemon),
+				zap.String("Torrent", id),
+				zap.String("Name", t.Name),
+				zap.String("Action", entry.Action),
+				zap.Bool("DryRun", entry.DryRun),
+				zap.String("Error", entry.Error),
+			)
+
+			entries = append(entries, entry)
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+func (e *RuleEngine) act(conn deluge.DelugeClient, rule *compiledRule, id string) error {
+	switch rule.Action {
+	case RuleActionPause:
+		return conn.PauseTorrents(id)
+	case RuleActionRemove:
+		ok, err := conn.RemoveTorrent(id, rule.RemoveFiles)
+		if err == nil && !ok {
+			err = fmt.Errorf("torrent could not be removed")
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("unknown action %q", rule.Action)
+}
+
+// httpRules lists all rules
+func (api *Api) httpRules(_ *http.Request) (interface{}, error) {
+	return api.rules.config.Store.List(), nil
+}
+
+// validateRule reads a rule from the request body and checks that its daemon exists.
+func (api *Api) validateRule(r *http.Request) (Rule, error) {
+	var rule Rule
+
+	err := Read(r, &rule)
+	if err != nil {
+		return rule, err
+	}
+
+	// An empty daemon applies the rule to every daemon
+	if rule.Daemon != "" {
+		_, err = api.daemons.Get(rule.Daemon)
+		if err != nil {
+			return rule, Hint(http.StatusBadRequest, err)
+		}
+	}
+
+	return rule, nil
+}
+
+// httpCreateRule creates a new rule
+func (api *Api) httpCreateRule(r *http.Request) (interface{}, error) {
+	rule, err := api.validateRule(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.rules.config.Store.Create(rule)
+}
+
+// httpUpdateRule replaces an existing rule
+func (api *Api) httpUpdateRule(r *http.Request) (interface{}, error) {
+	rule, err := api.validateRule(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.rules.config.Store.Update(mux.Vars(r)["id"], rule)
+}
+
+// httpDeleteRule deletes a rule
+func (api *Api) httpDeleteRule(r *http.Request) (interface{}, error) {
+	return nil, api.rules.config.Store.Delete(mux.Vars(r)["id"])
+}
+
+// httpRunRules evaluates the rules now.
+//
+//	?dryRun=true	Return the actions that would be taken without taking or recording them
+func (api *Api) httpRunRules(r *http.Request) (interface{}, error) {
+	return api.rules.Run(r.Context(), r.URL.Query().Get("dryRun") == "true"), nil
+}
+
+// httpRulesAudit gets the most recent actions taken by rules
+func (api *Api) httpRulesAudit(_ *http.Request) (interface{}, error) {
+	return api.rules.Audit(), nil
+}
@@ -0,0 +1,433 @@
+package storm
+
+import (
+	"context"
+	deluge "github.com/gdm85/go-libdeluge"
+	"go.uber.org/zap"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRuleExpressionPrecedence(t *testing.T) {
+	expr, err := parseRuleExpression("label=tv AND ratio>=2 OR seeding_time>14d")
+	if err != nil {
+		t.Fatalf("parseRuleExpression() error = %v", err)
+	}
+
+	// AND binds more tightly than OR
+	if len(expr) != 2 || len(expr[0]) != 2 || len(expr[1]) != 1 {
+		t.Fatalf("parseRuleExpression() = %d alternatives, want (label AND ratio) OR seeding_time", len(expr))
+	}
+
+	const day = 24 * 60 * 60
+
+	tests := []struct {
+		name        string
+		label       string
+		ratio       float32
+		seedingTime int64
+		want        bool
+	}{
+		{"label and ratio", "tv", 2, 0, true},
+		{"label without ratio", "tv", 1.5, 0, false},
+		{"ratio without label", "movies", 3, 0, false},
+		{"seeding time without label or ratio", "movies", 0, 15 * day, true},
+		{"label without ratio but seeding time", "tv", 0, 15 * day, true},
+		{"seeding time not long enough", "tv", 1, 14 * day, false},
+		{"label is case insensitive", "TV", 2, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent := &ruleTorrent{
+				ID:    "1",
+				Label: tt.label,
+				TorrentStatus: &deluge.TorrentStatus{
+					Ratio:       tt.ratio,
+					SeedingTime: tt.seedingTime,
+				},
+			}
+
+			if got := expr.match(torrent, time.Now()); got != tt.want {
+				t.Errorf("match() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleExpressionValues(t *testing.T) {
+	tests := []struct {
+		input string
+		num   float64
+	}{
+		{"seeding_time>14d", 14 * 24 * 60 * 60},
+		{"seeding_time>12h", 12 * 60 * 60},
+		{"active_time>=30m", 30 * 60},
+		{"age<90", 90},
+		{"size>10G", 10 << 30},
+		{"size<=512MiB", 512 << 20},
+		{"size>1kb", 1 << 10},
+		{"size=100", 100},
+		{"ratio>=1.5", 1.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := parseRuleExpression(tt.input)
+			if err != nil {
+				t.Fatalf("parseRuleExpression() error = %v", err)
+			}
+
+			if got := expr[0][0].num; got != tt.num {
+				t.Errorf("value = %v, want %v", got, tt.num)
+			}
+		})
+	}
+}
+
+func TestParseRuleExpressionEmptyValue(t *testing.T) {
+	expr, err := parseRuleExpression(`label="" AND ratio>=2`)
+	if err != nil {
+		t.Fatalf("parseRuleExpression() error = %v", err)
+	}
+
+	torrent := &ruleTorrent{ID: "1", TorrentStatus: &deluge.TorrentStatus{Ratio: 2}}
+	if !expr.match(torrent, time.Now()) {
+		t.Error("match() of a torrent without a label = false, want true")
+	}
+
+	torrent.Label = "tv"
+	if expr.match(torrent, time.Now()) {
+		t.Error("match() of a torrent with a label = true, want false")
+	}
+}
+
+func TestParseRuleExpressionInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", " "},
+		{"unknown field", "seeders>10"},
+		{"no operator", "ratio 2"},
+		{"missing value", "ratio>="},
+		{"missing string value", "label="},
+		{"missing condition after AND", "label=tv AND"},
+		{"missing condition before OR", "OR ratio>2"},
+		{"missing condition between AND and OR", "label=tv AND OR ratio>2"},
+		{"bad duration unit", "seeding_time>14w"},
+		{"bad size unit", "size>10Q"},
+		{"bad number", "ratio>two"},
+		{"comparison of a string field", "label>tv"},
+		{"pattern on a number field", "ratio=~2"},
+		{"bad pattern", "name=~("},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRuleExpression(tt.input)
+			if err == nil {
+				t.Errorf("parseRuleExpression(%q) expected an error", tt.input)
+			}
+		})
+	}
+}
+
+// ruleTestClient is a Deluge client with a fixed set of torrents that records the actions taken on them.
+type ruleTestClient struct {
+	deluge.DelugeClient
+
+	torrents map[string]*deluge.TorrentStatus
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func (c *ruleTestClient) Connect() error { return nil }
+
+func (c *ruleTestClient) Close() error { return nil }
+
+func (c *ruleTestClient) TorrentsStatus(_ deluge.TorrentState, _ []string) (map[string]*deluge.TorrentStatus, error) {
+	return c.torrents, nil
+}
+
+func (c *ruleTestClient) PauseTorrents(ids ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		c.actions = append(c.actions, "pause "+id)
+	}
+
+	return nil
+}
+
+func (c *ruleTestClient) RemoveTorrent(id string, rmFiles bool) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rmFiles {
+		c.actions = append(c.actions, "remove with files "+id)
+	} else {
+		c.actions = append(c.actions, "remove "+id)
+	}
+
+	return true, nil
+}
+
+func (c *ruleTestClient) Actions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.actions...)
+}
+
+// newRuleTestClient returns a client with a seeding torrent 1 of ratio 3, a seeding torrent 2 of ratio 0.5
+// and a paused torrent 3 of ratio 3.
+func newRuleTestClient() *ruleTestClient {
+	return &ruleTestClient{
+		torrents: map[string]*deluge.TorrentStatus{
+			"1": {Name: "One", State: "Seeding", Ratio: 3},
+			"2": {Name: "Two", State: "Seeding", Ratio: 0.5},
+			"3": {Name: "Three", State: "Paused", Ratio: 3},
+		},
+	}
+}
+
+func TestRuleEngineRunDaemon(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      string
+		removeFiles bool
+		dryRun      bool
+		ruleDryRun  bool
+		entries     []string
+		actions     []string
+	}{
+		{
+			name:    "pause",
+			action:  RuleActionPause,
+			entries: []string{"pause 1"},
+			actions: []string{"pause 1"},
+		},
+		{
+			name:    "pause dry run",
+			action:  RuleActionPause,
+			dryRun:  true,
+			entries: []string{"pause 1 (dry run)"},
+		},
+		{
+			name:    "remove",
+			action:  RuleActionRemove,
+			entries: []string{"remove 1", "remove 3"},
+			actions: []string{"remove 1", "remove 3"},
+		},
+		{
+			name:    "remove dry run",
+			action:  RuleActionRemove,
+			dryRun:  true,
+			entries: []string{"remove 1 (dry run)", "remove 3 (dry run)"},
+		},
+		{
+			name:        "remove with files",
+			action:      RuleActionRemove,
+			removeFiles: true,
+			entries:     []string{"remove with files 1", "remove with files 3"},
+			actions:     []string{"remove with files 1", "remove with files 3"},
+		},
+		{
+			name:        "remove with files dry run",
+			action:      RuleActionRemove,
+			removeFiles: true,
+			dryRun:      true,
+			entries:     []string{"remove with files 1 (dry run)", "remove with files 3 (dry run)"},
+		},
+		{
+			name:       "remove with a dry run rule",
+			action:     RuleActionRemove,
+			ruleDryRun: true,
+			entries:    []string{"remove 1 (dry run)", "remove 3 (dry run)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := compileRule(Rule{
+				ID:          "rule",
+				Name:        "Seeded",
+				When:        "ratio>=2",
+				Action:      tt.action,
+				RemoveFiles: tt.removeFiles,
+				Enabled:     true,
+				DryRun:      tt.ruleDryRun,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				client = newRuleTestClient()
+				engine = NewRuleEngine(zap.NewNop(), NewDaemons(), RulesConfig{Store: new(RuleStore)})
+			)
+
+			entries, err := engine.runDaemon(client, "default", []*compiledRule{rule}, tt.dryRun)
+			if err != nil {
+				t.Fatalf("runDaemon() error = %v", err)
+			}
+
+			var got []string
+			for _, entry := range entries {
+				if entry.Rule != "Seeded" || entry.RuleID != "rule" || entry.Daemon != "default" || entry.Error != "" {
+					t.Errorf("entry = %+v, want a successful action by rule Seeded on daemon default", entry)
+				}
+
+				s := entry.Action + " " + entry.Torrent
+				if entry.DryRun {
+					s += " (dry run)"
+				}
+
+				got = append(got, s)
+			}
+
+			if !reflect.DeepEqual(got, tt.entries) {
+				t.Errorf("runDaemon() entries = %v, want %v", got, tt.entries)
+			}
+			if actions := client.Actions(); !reflect.DeepEqual(actions, tt.actions) {
+				t.Errorf("runDaemon() actions = %v, want %v", actions, tt.actions)
+			}
+		})
+	}
+}
+
+func TestRuleEngineRunDaemonFirstMatch(t *testing.T) {
+	var rules []*compiledRule
+	for _, rule := range []Rule{
+		{ID: "pause", Name: "Pause", When: "ratio>=2", Action: RuleActionPause, Enabled: true},
+		{ID: "remove", Name: "Remove", When: "ratio>=0", Action: RuleActionRemove, Enabled: true},
+	} {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	var (
+		client = newRuleTestClient()
+		engine = NewRuleEngine(zap.NewNop(), NewDaemons(), RulesConfig{Store: new(RuleStore)})
+	)
+
+	_, err := engine.runDaemon(client, "default", rules, false)
+	if err != nil {
+		t.Fatalf("runDaemon() error = %v", err)
+	}
+
+	// Torrents matched by the pause rule are never removed, even if they are already paused
+	if want := []string{"pause 1", "remove 2"}; !reflect.DeepEqual(client.Actions(), want) {
+		t.Errorf("runDaemon() actions = %v, want %v", client.Actions(), want)
+	}
+}
+
+func TestRuleEngineRunAudit(t *testing.T) {
+	tests := []struct {
+		name         string
+		dryRun       bool
+		configDryRun bool
+		ruleDryRun   bool
+		recorded     bool
+	}{
+		{name: "scheduled", recorded: true},
+		{name: "scheduled with dry run rule", ruleDryRun: true, recorded: true},
+		{name: "scheduled with dry run config", configDryRun: true, recorded: true},
+		{name: "manual dry run", dryRun: true},
+		{name: "manual dry run with dry run rule", dryRun: true, ruleDryRun: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				client = newRuleTestClient()
+				pool   = NewConnectionPool(zap.NewNop(), 1, time.Minute, func() deluge.DelugeClient { return client })
+			)
+
+			defer pool.Close()
+
+			daemons := NewDaemons()
+			if err := daemons.Add("default", pool); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := OpenRuleStore(filepath.Join(t.TempDir(), "rules.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = store.Create(Rule{Name: "Seeded", When: "ratio>=2", Action: RuleActionPause, Enabled: true, DryRun: tt.ruleDryRun})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			engine := NewRuleEngine(zap.NewNop(), daemons, RulesConfig{Store: store, DryRun: tt.configDryRun})
+
+			entries := engine.Run(context.Background(), tt.dryRun)
+			if len(entries) != 1 {
+				t.Fatalf("Run() = %d entries, want 1", len(entries))
+			}
+
+			dryRun := tt.dryRun || tt.configDryRun || tt.ruleDryRun
+			if entries[0].DryRun != dryRun {
+				t.Errorf("Run() DryRun = %t, want %t", entries[0].DryRun, dryRun)
+			}
+			if paused := len(client.Actions()) == 1; paused == dryRun {
+				t.Errorf("Run() actions = %v with dry run %t", client.Actions(), dryRun)
+			}
+
+			if recorded := len(store.Audit()) == 1; recorded != tt.recorded {
+				t.Errorf("Run() recorded in the audit log = %t, want %t", recorded, tt.recorded)
+			}
+
+			// The audit log is persisted
+			reopened, err := OpenRuleStore(store.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorded := len(reopened.Audit()) == 1; recorded != tt.recorded {
+				t.Errorf("Run() persisted in the audit log = %t, want %t", recorded, tt.recorded)
+			}
+		})
+	}
+}
+
+func TestRuleEngineRunUnknownDaemon(t *testing.T) {
+	var (
+		client = newRuleTestClient()
+		pool   = NewConnectionPool(zap.NewNop(), 1, time.Minute, func() deluge.DelugeClient { return client })
+	)
+
+	defer pool.Close()
+
+	daemons := NewDaemons()
+	if err := daemons.Add("default", pool); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenRuleStore(filepath.Join(t.TempDir(), "rules.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Create(Rule{Name: "Seeded", When: "ratio>=2", Action: RuleActionPause, Daemon: "removed", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewRuleEngine(zap.NewNop(), daemons, RulesConfig{Store: store})
+
+	if entries := engine.Run(context.Background(), false); len(entries) != 0 || len(client.Actions()) != 0 {
+		t.Errorf("Run() = %d entries, want a rule of an unknown daemon not to run", len(entries))
+	}
+}
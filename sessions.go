@@ -0,0 +1,238 @@
+package storm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSessionExpiry is how long a login session lasts if not configured.
+const DefaultSessionExpiry = time.Hour * 24 * 7
+
+// Session is a logged in session.
+// An empty Name is a session created using the API key.
+type Session struct {
+	Name    string
+	Created time.Time
+	Expires time.Time
+}
+
+// Expired returns true if the session has expired at time t.
+func (s *Session) Expired(t time.Time) bool {
+	return !t.Before(s.Expires)
+}
+
+// NewSessions creates a new in-memory session store.
+// Sessions expire after expiry.
+func NewSessions(expiry time.Duration) *Sessions {
+	if expiry <= 0 {
+		expiry = DefaultSessionExpiry
+	}
+
+	return &Sessions{
+		expiry:   expiry,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Sessions stores login sessions in memory.
+// Only a hash of each session token is stored so that the tokens themselves cannot be recovered from the store.
+type Sessions struct {
+	expiry time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// sessionKey returns the key of a session token in the store.
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// prune removes all expired sessions. The caller must hold the lock.
+func (s *Sessions) prune(now time.Time) {
+	for key, session := range s.sessions {
+		if session.Expired(now) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// Create creates a new session for the named user and returns its token.
+func (s *Sessions) Create(name string) (string, *Session, error) {
+	var b [32]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		token   = base64.RawURLEncoding.EncodeToString(b[:])
+		now     = time.Now().UTC()
+		session = &Session{
+			Name:    name,
+			Created: now,
+			Expires: now.Add(s.expiry),
+		}
+	)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+	s.sessions[sessionKey(token)] = session
+
+	return token, session, nil
+}
+
+// Get gets the session for a token if it exists and has not expired.
+func (s *Sessions) Get(token string) (Session, bool) {
+	key := sessionKey(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[key]
+	if !ok {
+		return Session{}, false
+	}
+
+	if session.Expired(time.Now()) {
+		delete(s.sessions, key)
+		return Session{}, false
+	}
+
+	return *session, true
+}
+
+// Revoke ends the session for a token.
+func (s *Sessions) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionKey(token))
+}
+
+// RevokeUser ends all sessions of the named user.
+func (s *Sessions) RevokeUser(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, session := range s.sessions {
+		if session.Name == name {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// sessionPrincipal returns the principal of a session.
+// Sessions of users that no longer exist are not valid.
+func (api *Api) sessionPrincipal(session Session) (*Principal, bool) {
+	if session.Name == "" {
+		return &Principal{Role: RoleAdmin}, api.apiKey != ""
+	}
+
+	if api.users == nil {
+		return nil, false
+	}
+
+	user, ok := api.users.Get(session.Name)
+	if !ok {
+		return nil, false
+	}
+
+	return &Principal{Name: user.Name, Role: user.Role}, true
+}
+
+// setSessionCookie sets the cookie containing the session token.
+// An empty token clears the cookie.
+func (api *Api) setSessionCookie(rw http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     ApiAuthCookieName,
+		Value:    token,
+		Path:     fmt.Sprintf("%s/api", api.pathPrefix),
+		Expires:  expires,
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	}
+
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(rw, cookie)
+}
+
+type LoginRequest struct {
+	// Username is the name of the user to log in as.
+	// Leave empty to log in using the API key as the password.
+	Username string
+	Password string
+}
+
+type SessionResponse struct {
+	Name    string
+	Role    Role
+	Expires time.Time
+}
+
+// httpLogin creates a new session and sets the session cookie
+func (api *Api) httpLogin(rw http.ResponseWriter, r *http.Request) {
+	if locked := api.lockedOut(r); locked != nil {
+		sendAuthError(rw, locked)
+		return
+	}
+
+	_ = Handle(rw, r, func(r *http.Request) (interface{}, error) {
+		if !api.authEnabled() {
+			return nil, &Error{Code: http.StatusBadRequest, Message: "Authentication is not enabled"}
+		}
+
+		var req LoginRequest
+
+		err := Read(r, &req)
+		if err != nil {
+			return nil, err
+		}
+
+		principal, err := api.authenticate(req.Username, req.Password)
+		api.recordAuthentication(r, req.Username, err)
+		if err != nil {
+			return nil, err
+		}
+
+		token, session, err := api.sessions.Create(principal.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		api.setSessionCookie(rw, r, token, session.Expires)
+
+		return SessionResponse{
+			Name:    principal.Name,
+			Role:    principal.Role,
+			Expires: session.Expires,
+		}, nil
+	})
+}
+
+// httpLogout revokes the current session and clears the session cookie
+func (api *Api) httpLogout(rw http.ResponseWriter, r *http.Request) {
+	_ = Handle(rw, r, func(r *http.Request) (interface{}, error) {
+		cookie, err := r.Cookie(ApiAuthCookieName)
+		if err == nil {
+			api.sessions.Revoke(cookie.Value)
+		}
+
+		api.setSessionCookie(rw, r, "", time.Unix(0, 0))
+
+		return nil, nil
+	})
+}
@@ -0,0 +1,388 @@
+package storm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"go.uber.org/zap"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// ViewStreamInterval is the interval between polls of the Deluge daemons by the view stream.
+	ViewStreamInterval = time.Second * 2
+	// viewStreamBuffer is the number of events buffered for each subscriber.
+	// Subscribers that fall behind by more than this number of events are disconnected.
+	viewStreamBuffer = 16
+)
+
+// TorrentRef identifies a torrent on a daemon.
+type TorrentRef struct {
+	Daemon string
+	Hash   string
+}
+
+// TorrentChange contains the fields of a torrent that have changed since the last poll.
+type TorrentChange struct {
+	TorrentRef
+	Fields map[string]json.RawMessage
+}
+
+// ViewDelta is the difference between two successive views.
+type ViewDelta struct {
+	Added    []*ViewTorrent         `json:",omitempty"`
+	Removed  []TorrentRef           `json:",omitempty"`
+	Changed  []*TorrentChange       `json:",omitempty"`
+	Session  *deluge.SessionStatus  `json:",omitempty"`
+	DiskFree *int64                 `json:",omitempty"`
+	Daemons  map[string]*DaemonView `json:",omitempty"`
+}
+
+// Empty returns true if nothing changed between the two views.
+func (d *ViewDelta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		d.Session == nil && d.DiskFree == nil && d.Daemons == nil
+}
+
+// torrentFields encodes the fields of a torrent as JSON so that they can be compared.
+func torrentFields(t *ViewTorrent) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+
+	b, _ := json.Marshal(t)
+	_ = json.Unmarshal(b, &fields)
+
+	return fields
+}
+
+// indexTorrents indexes the torrents of a view by their daemon and hash.
+func indexTorrents(update *ViewUpdate) map[TorrentRef]*ViewTorrent {
+	index := make(map[TorrentRef]*ViewTorrent)
+	if update == nil {
+		return index
+	}
+
+	for _, t := range update.Torrents {
+		index[TorrentRef{Daemon: t.Daemon, Hash: t.Hash}] = t
+	}
+
+	return index
+}
+
+// keepUnreachable returns next with the torrents of any daemon that could not be polled copied from previous,
+// so that a daemon failing a single poll does not appear to remove all of its torrents.
+func keepUnreachable(previous, next *ViewUpdate) *ViewUpdate {
+	if previous == nil {
+		return next
+	}
+
+	var unreachable = make(map[string]bool)
+	for name, d := range next.Daemons {
+		if d.Error != "" {
+			unreachable[name] = true
+		}
+	}
+
+	if len(unreachable) == 0 {
+		return next
+	}
+
+	kept := *next
+	kept.Torrents = append([]*ViewTorrent(nil), next.Torrents...)
+
+	for _, t := range previous.Torrents {
+		if unreachable[t.Daemon] {
+			kept.Torrents = append(kept.Torrents, t)
+		}
+	}
+
+	sortViewTorrents(kept.Torrents)
+
+	return &kept
+}
+
+// diffView calculates the delta between the previous and next view.
+// If previous is nil then every torrent in next is added.
+func diffView(previous, next *ViewUpdate) *ViewDelta {
+	var (
+		delta  = new(ViewDelta)
+		before = indexTorrents(previous)
+	)
+
+	for _, t := range next.Torrents {
+		ref := TorrentRef{Daemon: t.Daemon, Hash: t.Hash}
+
+		old, ok := before[ref]
+		if !ok {
+			delta.Added = append(delta.Added, t)
+			continue
+		}
+
+		delete(before, ref)
+
+		var (
+			oldFields = torrentFields(old)
+			changed   = make(map[string]json.RawMessage)
+		)
+
+		for k, v := range torrentFields(t) {
+			if !bytes.Equal(oldFields[k], v) {
+				changed[k] = v
+			}
+		}
+
+		if len(changed) > 0 {
+			delta.Changed = append(delta.Changed, &TorrentChange{
+				TorrentRef: ref,
+				Fields:     changed,
+			})
+		}
+	}
+
+	// Any torrents left over from the previous view have been removed
+	if previous != nil {
+		for _, t := range previous.Torrents {
+			ref := TorrentRef{Daemon: t.Daemon, Hash: t.Hash}
+			if _, ok := before[ref]; ok {
+				delta.Removed = append(delta.Removed, ref)
+			}
+		}
+	}
+
+	if previous == nil || !reflect.DeepEqual(previous.Session, next.Session) {
+		delta.Session = next.Session
+	}
+
+	if previous == nil || previous.DiskFree != next.DiskFree {
+		diskFree := next.DiskFree
+		delta.DiskFree = &diskFree
+	}
+
+	if previous == nil || !reflect.DeepEqual(previous.Daemons, next.Daemons) {
+		delta.Daemons = next.Daemons
+	}
+
+	return delta
+}
+
+// ViewEvent is sent to view stream subscribers after each poll.
+type ViewEvent struct {
+	// Update is the complete view after the poll.
+	Update *ViewUpdate
+	// Delta is the difference between the previous poll and Update.
+	Delta *ViewDelta
+	// Err is set if the poll failed. Update and Delta are nil.
+	Err error
+}
+
+// ViewSubscription receives events from a ViewStream.
+type ViewSubscription struct {
+	// Initial is the most recent view at the time of subscription, if one is available.
+	Initial *ViewUpdate
+	// Events receives an event after each poll of the stream.
+	// The channel is closed if the subscriber falls too far behind or the subscription is cancelled.
+	Events <-chan *ViewEvent
+
+	events chan *ViewEvent
+}
+
+// ViewFetcher fetches the current view.
+type ViewFetcher func(ctx context.Context) (*ViewUpdate, error)
+
+// NewViewStream creates a new ViewStream.
+// The stream only polls using fetch whilst there is at least one subscriber.
+func NewViewStream(log *zap.Logger, interval time.Duration, fetch ViewFetcher) *ViewStream {
+	return &ViewStream{
+		Log:         log,
+		Interval:    interval,
+		fetch:       fetch,
+		subscribers: make(map[*ViewSubscription]struct{}),
+	}
+}
+
+// ViewStream shares a single poller of the Deluge daemons between many subscribers.
+type ViewStream struct {
+	Log      *zap.Logger
+	Interval time.Duration
+
+	fetch ViewFetcher
+
+	mu          sync.Mutex
+	subscribers map[*ViewSubscription]struct{}
+	current     *ViewUpdate
+	cancel      context.CancelFunc
+}
+
+// Subscribe adds a new subscriber to the stream, starting the poller if this is the first subscriber.
+func (s *ViewStream) Subscribe() *ViewSubscription {
+	events := make(chan *ViewEvent, viewStreamBuffer)
+	sub := &ViewSubscription{
+		Events: events,
+		events: events,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub.Initial = s.current
+	s.subscribers[sub] = struct{}{}
+
+	if s.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		go s.poll(ctx)
+	}
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber from the stream, stopping the poller if there are no more subscribers.
+func (s *ViewStream) Unsubscribe(sub *ViewSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(sub)
+}
+
+func (s *ViewStream) remove(sub *ViewSubscription) {
+	if _, ok := s.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(s.subscribers, sub)
+	close(sub.events)
+
+	if len(s.subscribers) == 0 && s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+		// The view is not kept up to date without a poller
+		s.current = nil
+	}
+}
+
+func (s *ViewStream) broadcast(ctx context.Context, event *ViewEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The poller has been stopped since the event was fetched
+	if ctx.Err() != nil {
+		return
+	}
+
+	if event.Update != nil {
+		s.current = event.Update
+	}
+
+	for sub := range s.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			s.Log.Warn("Disconnecting view stream subscriber that has fallen behind")
+			s.remove(sub)
+		}
+	}
+}
+
+func (s *ViewStream) poll(ctx context.Context) {
+	var (
+		ticker   = time.NewTicker(s.Interval)
+		previous *ViewUpdate
+	)
+
+	defer ticker.Stop()
+
+	for {
+		next, err := s.fetch(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			s.broadcast(ctx, &ViewEvent{Err: err})
+		default:
+			next = keepUnreachable(previous, next)
+
+			delta := diffView(previous, next)
+			if previous == nil || !delta.Empty() {
+				s.broadcast(ctx, &ViewEvent{Update: next, Delta: delta})
+			}
+			previous = next
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendEvent writes a single server-sent event to the client.
+func sendEvent(rw http.ResponseWriter, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event, b)
+	if err != nil {
+		return err
+	}
+
+	rw.(http.Flusher).Flush()
+	return nil
+}
+
+// httpViewStream streams view updates to the client as server-sent events.
+// The first event is a snapshot event containing the complete view,
+// subsequent delta events contain only the changes since the previous event.
+// Failures to poll the daemons are sent as error events.
+func (api *Api) httpViewStream(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := rw.(http.Flusher); !ok {
+		SendError(rw, &Error{Code: http.StatusNotImplemented, Message: "Streaming is not supported"})
+		return
+	}
+
+	sub := api.stream.Subscribe()
+	defer api.stream.Unsubscribe(sub)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	var sentSnapshot = sub.Initial != nil
+	if sentSnapshot {
+		if sendEvent(rw, "snapshot", sub.Initial) != nil {
+			return
+		}
+	}
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			switch {
+			case !ok:
+				return
+			case event.Err != nil:
+				err = sendEvent(rw, "error", &errorResponse{Error: event.Err.Error()})
+			case !sentSnapshot:
+				sentSnapshot = true
+				err = sendEvent(rw, "snapshot", event.Update)
+			default:
+				err = sendEvent(rw, "delta", event.Delta)
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package storm
+
+import (
+	deluge "github.com/gdm85/go-libdeluge"
+	"reflect"
+	"testing"
+)
+
+// testViewTorrent returns a torrent on daemon with the given state and progress.
+func testViewTorrent(daemon, hash, state string, progress float32) *ViewTorrent {
+	return &ViewTorrent{
+		Hash:   hash,
+		Daemon: daemon,
+		TorrentStatus: &deluge.TorrentStatus{
+			Name:     hash,
+			State:    state,
+			Progress: progress,
+		},
+	}
+}
+
+// testViewUpdate returns a view of torrents polled from daemons.
+// Daemons listed in unreachable could not be polled.
+func testViewUpdate(daemons []string, unreachable []string, torrents ...*ViewTorrent) *ViewUpdate {
+	update := &ViewUpdate{
+		Torrents: torrents,
+		Session:  new(deluge.SessionStatus),
+		Daemons:  make(map[string]*DaemonView),
+	}
+
+	for _, name := range daemons {
+		update.Daemons[name] = &DaemonView{Session: new(deluge.SessionStatus)}
+	}
+	for _, name := range unreachable {
+		update.Daemons[name] = &DaemonView{Error: "connection refused"}
+	}
+
+	sortViewTorrents(update.Torrents)
+
+	return update
+}
+
+func TestDiffView(t *testing.T) {
+	var (
+		daemons  = []string{"a", "b"}
+		previous = testViewUpdate(daemons, nil,
+			testViewTorrent("a", "1", "Downloading", 50),
+			testViewTorrent("a", "2", "Seeding", 100),
+			testViewTorrent("b", "1", "Paused", 10),
+		)
+		next = testViewUpdate(daemons, nil,
+			testViewTorrent("a", "1", "Downloading", 75),
+			testViewTorrent("a", "2", "Seeding", 100),
+			testViewTorrent("b", "3", "Queued", 0),
+		)
+	)
+
+	delta := diffView(previous, next)
+
+	if len(delta.Added) != 1 || delta.Added[0].Daemon != "b" || delta.Added[0].Hash != "3" {
+		t.Errorf("Added = %+v, want b/3", delta.Added)
+	}
+
+	// A torrent of the same hash on another daemon is a different torrent
+	if want := []TorrentRef{{Daemon: "b", Hash: "1"}}; !reflect.DeepEqual(delta.Removed, want) {
+		t.Errorf("Removed = %+v, want %+v", delta.Removed, want)
+	}
+
+	if len(delta.Changed) != 1 {
+		t.Fatalf("Changed = %d torrents, want 1", len(delta.Changed))
+	}
+
+	changed := delta.Changed[0]
+	if changed.TorrentRef != (TorrentRef{Daemon: "a", Hash: "1"}) {
+		t.Errorf("Changed torrent = %+v, want a/1", changed.TorrentRef)
+	}
+	if len(changed.Fields) != 1 || string(changed.Fields["Progress"]) != "75" {
+		t.Errorf("Changed fields = %s, want only Progress", changed.Fields)
+	}
+
+	// Nothing else changed between the views
+	if delta.Session != nil || delta.DiskFree != nil || delta.Daemons != nil {
+		t.Errorf("delta = %+v, want no session, disk or daemon changes", delta)
+	}
+}
+
+func TestDiffViewInitial(t *testing.T) {
+	next := testViewUpdate([]string{"a"}, nil,
+		testViewTorrent("a", "1", "Seeding", 100),
+		testViewTorrent("a", "2", "Paused", 0),
+	)
+
+	delta := diffView(nil, next)
+
+	if len(delta.Added) != 2 || len(delta.Removed) != 0 || len(delta.Changed) != 0 {
+		t.Errorf("delta = %+v, want every torrent added", delta)
+	}
+	if delta.Session == nil || delta.DiskFree == nil || delta.Daemons == nil {
+		t.Errorf("delta = %+v, want the session, disk and daemons", delta)
+	}
+}
+
+func TestDiffViewUnchanged(t *testing.T) {
+	var (
+		previous = testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Seeding", 100))
+		next     = testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Seeding", 100))
+	)
+
+	if delta := diffView(previous, next); !delta.Empty() {
+		t.Errorf("delta = %+v, want empty", delta)
+	}
+}
+
+func TestKeepUnreachable(t *testing.T) {
+	var (
+		previous = testViewUpdate([]string{"a", "b"}, nil,
+			testViewTorrent("a", "1", "Seeding", 100),
+			testViewTorrent("b", "2", "Seeding", 100),
+		)
+		next = testViewUpdate([]string{"a"}, []string{"b"},
+			testViewTorrent("a", "1", "Seeding", 100),
+		)
+	)
+
+	kept := keepUnreachable(previous, next)
+
+	if len(kept.Torrents) != 2 {
+		t.Fatalf("Torrents = %d, want the torrent of the unreachable daemon kept", len(kept.Torrents))
+	}
+	if len(next.Torrents) != 1 {
+		t.Error("keepUnreachable modified next")
+	}
+
+	delta := diffView(previous, kept)
+	if len(delta.Removed) != 0 || len(delta.Added) != 0 || len(delta.Changed) != 0 {
+		t.Errorf("delta = %+v, want no torrent changes", delta)
+	}
+	if delta.Daemons == nil {
+		t.Error("delta does not report the unreachable daemon")
+	}
+
+	// A daemon that was polled successfully still removes its torrents
+	next = testViewUpdate([]string{"a", "b"}, nil)
+	if kept := keepUnreachable(previous, next); len(kept.Torrents) != 0 {
+		t.Errorf("Torrents = %d, want 0", len(kept.Torrents))
+	}
+}
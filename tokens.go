@@ -0,0 +1,377 @@
+package storm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiTokenPrefix is prepended to every API token to make them easy to recognise.
+	apiTokenPrefix = "storm_"
+	// apiTokenLastUsedPrecision is how often changes to the last used time of a token are saved.
+	apiTokenLastUsedPrecision = time.Minute
+)
+
+// APIToken is a named token used by automated clients to access the API.
+// The token itself is never stored, only its SHA-256 hash.
+type APIToken struct {
+	ID        string
+	Name      string
+	Scopes    []Permission
+	Hash      string
+	CreatedBy string
+	Created   time.Time
+	LastUsed  *time.Time
+}
+
+type tokensFile struct {
+	Tokens []*APIToken
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// OpenTokenStore opens the file-backed API token store at path.
+// The file is created when the first token is created.
+func OpenTokenStore(path string) (*TokenStore, error) {
+	var f tokensFile
+
+	err := loadJSON(path, &f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API tokens from %s: %w", path, err)
+	}
+
+	store := &TokenStore{
+		path:   path,
+		tokens: make(map[string]*APIToken),
+		saved:  make(map[string]time.Time),
+	}
+
+	for _, t := range f.Tokens {
+		store.tokens[t.Hash] = t
+		if t.LastUsed != nil {
+			store.saved[t.Hash] = *t.LastUsed
+		}
+	}
+
+	return store, nil
+}
+
+// TokenStore is a file-backed store of API tokens.
+type TokenStore struct {
+	path string
+
+	mu     sync.Mutex
+	tokens map[string]*APIToken
+	// saved is the last used time of each token as it was last saved
+	saved map[string]time.Time
+}
+
+// save persists the store. The caller must hold the lock.
+func (s *TokenStore) save() error {
+	f := tokensFile{
+		Tokens: make([]*APIToken, 0, len(s.tokens)),
+	}
+
+	for _, t := range s.tokens {
+		f.Tokens = append(f.Tokens, t)
+	}
+
+	sort.Slice(f.Tokens, func(i, j int) bool {
+		return f.Tokens[i].Created.Before(f.Tokens[j].Created)
+	})
+
+	return saveJSON(s.path, &f)
+}
+
+// List returns all tokens, oldest first.
+func (s *TokenStore) List() []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, *t)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+
+	return tokens
+}
+
+// Create mints a new token with the given scopes.
+// The returned token string is not stored and cannot be retrieved later.
+func (s *TokenStore) Create(name string, scopes []Permission, createdBy string) (string, APIToken, error) {
+	if name == "" {
+		return "", APIToken{}, &Error{Code: http.StatusBadRequest, Message: "Token name must not be empty"}
+	}
+	if len(scopes) == 0 {
+		return "", APIToken{}, &Error{Code: http.StatusBadRequest, Message: "Token must have at least one scope"}
+	}
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return "", APIToken{}, &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unknown scope %q", scope)}
+		}
+	}
+
+	var b [32]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	var (
+		secret = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b[:])
+		token  = &APIToken{
+			ID:        randomID()[:16],
+			Name:      name,
+			Scopes:    scopes,
+			Hash:      hashAPIToken(secret),
+			CreatedBy: createdBy,
+			Created:   time.Now().UTC(),
+		}
+	)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.Hash] = token
+
+	err = s.save()
+	if err != nil {
+		delete(s.tokens, token.Hash)
+		return "", APIToken{}, err
+	}
+
+	return secret, *token, nil
+}
+
+// Revoke deletes the token with the given ID.
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, t := range s.tokens {
+		if t.ID != id {
+			continue
+		}
+
+		delete(s.tokens, hash)
+
+		err := s.save()
+		if err != nil {
+			s.tokens[hash] = t
+		}
+
+		return err
+	}
+
+	return &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Token %q does not exist", id)}
+}
+
+// Authenticate looks up a token and records that it has been used.
+// The last used time is only saved to disk if it has changed by more than apiTokenLastUsedPrecision.
+func (s *TokenStore) Authenticate(secret string) (APIToken, bool) {
+	hash := hashAPIToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[hash]
+	if !ok {
+		return APIToken{}, false
+	}
+
+	now := time.Now().UTC()
+	t.LastUsed = &now
+
+	if now.Sub(s.saved[hash]) >= apiTokenLastUsedPrecision {
+		// Failing to record the last used time should not prevent the token from being used
+		if s.save() == nil {
+			s.saved[hash] = now
+		}
+	}
+
+	return *t, true
+}
+
+// bearerToken returns the token of a Bearer Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// authenticateToken returns the principal of an API token.
+func (api *Api) authenticateToken(secret string) (*Principal, error) {
+	if api.tokens == nil {
+		return nil, &Error{Code: http.StatusUnauthorized, Message: "API tokens are not enabled"}
+	}
+
+	t, ok := api.tokens.Authenticate(secret)
+	if !ok {
+		return nil, &Error{Code: http.StatusUnauthorized, Message: "Incorrect API token"}
+	}
+
+	creator, ok := api.tokenCreatorPermissions(t.CreatedBy)
+	if !ok {
+		return nil, &Error{Code: http.StatusUnauthorized, Message: "The creator of this API token no longer exists"}
+	}
+
+	// A token loses any scope that its creator no longer has, such as after the creator's role is changed
+	scopes := make([]Permission, 0, len(t.Scopes))
+	for _, scope := range t.Scopes {
+		for _, granted := range creator {
+			if scope == granted {
+				scopes = append(scopes, scope)
+				break
+			}
+		}
+	}
+
+	return &Principal{Name: t.Name, Scopes: scopes, TokenID: t.ID}, nil
+}
+
+// tokenCreator returns the creator recorded for a token created by p.
+// Only the API key and user accounts can create tokens, as the creator's current permissions must be found each time the token is used.
+func (api *Api) tokenCreator(p *Principal) (string, error) {
+	if p.TokenID != "" {
+		return "", &Error{Code: http.StatusForbidden, Message: "An API token cannot be used to create another API token"}
+	}
+
+	// The API key
+	if p.Name == "" {
+		return "", nil
+	}
+
+	if api.users != nil {
+		if _, ok := api.users.Get(p.Name); ok {
+			return p.Name, nil
+		}
+	}
+
+	return "", &Error{Code: http.StatusForbidden, Message: fmt.Sprintf("User %q must have a user account to create API tokens", p.Name)}
+}
+
+// tokenCreatorPermissions returns the current permissions of the creator of a token.
+// An empty creator is the API key.
+func (api *Api) tokenCreatorPermissions(createdBy string) ([]Permission, bool) {
+	if createdBy == "" {
+		return adminPermissions, api.apiKey != ""
+	}
+
+	if api.users == nil {
+		return nil, false
+	}
+
+	user, ok := api.users.Get(createdBy)
+	if !ok {
+		return nil, false
+	}
+
+	return rolePermissions[user.Role], true
+}
+
+type APITokenResponse struct {
+	ID        string
+	Name      string
+	Scopes    []Permission
+	CreatedBy string
+	Created   time.Time
+	LastUsed  *time.Time
+	// Token is only included when the token is created
+	Token string `json:",omitempty"`
+}
+
+func newAPITokenResponse(t APIToken) APITokenResponse {
+	return APITokenResponse{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		CreatedBy: t.CreatedBy,
+		Created:   t.Created,
+		LastUsed:  t.LastUsed,
+	}
+}
+
+type CreateAPITokenRequest struct {
+	Name   string
+	Scopes []Permission
+}
+
+// httpTokens lists all API tokens
+func (api *Api) httpTokens(_ *http.Request) (interface{}, error) {
+	var (
+		tokens   = api.tokens.List()
+		response = make([]APITokenResponse, 0, len(tokens))
+	)
+
+	for _, t := range tokens {
+		response = append(response, newAPITokenResponse(t))
+	}
+
+	return response, nil
+}
+
+// httpCreateToken mints a new API token.
+// A token cannot be given a scope that the principal creating it does not have.
+func (api *Api) httpCreateToken(r *http.Request) (interface{}, error) {
+	var req CreateAPITokenRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scope := range req.Scopes {
+		// Unknown scopes are rejected when the token is created
+		if !scope.Valid() {
+			continue
+		}
+
+		err = authorize(r, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p, _ := PrincipalFromRequest(r)
+
+	createdBy, err := api.tokenCreator(p)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, t, err := api.tokens.Create(req.Name, req.Scopes, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	response := newAPITokenResponse(t)
+	response.Token = secret
+
+	return response, nil
+}
+
+// httpRevokeToken revokes an API token
+func (api *Api) httpRevokeToken(r *http.Request) (interface{}, error) {
+	return nil, api.tokens.Revoke(mux.Vars(r)["id"])
+}
@@ -0,0 +1,154 @@
+package storm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTokenTestApi returns an Api with user accounts and API tokens enabled, and a user account named alice.
+func newTokenTestApi(t *testing.T) *Api {
+	dir := t.TempDir()
+
+	users, err := OpenUserStore(filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = users.Create("alice", "password", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := OpenTokenStore(filepath.Join(dir, "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Api{apiKey: "key", users: users, tokens: tokens}
+}
+
+// createTestToken creates a token with scopes as principal p.
+func createTestToken(api *Api, p *Principal, scopes string) (APITokenResponse, error) {
+	r := httptest.NewRequest(http.MethodPost, "/api/tokens", strings.NewReader(`{"Name": "script", "Scopes": `+scopes+`}`))
+
+	v, err := api.httpCreateToken(withPrincipal(r, p))
+	if err != nil {
+		return APITokenResponse{}, err
+	}
+
+	return v.(APITokenResponse), nil
+}
+
+func TestCreateToken(t *testing.T) {
+	api := newTokenTestApi(t)
+
+	for _, p := range []*Principal{
+		{Role: RoleAdmin},
+		{Name: "alice", Role: RoleAdmin},
+	} {
+		created, err := createTestToken(api, p, `["torrents:read", "tokens:admin"]`)
+		if err != nil {
+			t.Fatalf("httpCreateToken() as %q error = %v", p.Name, err)
+		}
+
+		if created.CreatedBy != p.Name {
+			t.Errorf("CreatedBy = %q, want %q", created.CreatedBy, p.Name)
+		}
+
+		principal, err := api.authenticateToken(created.Token)
+		if err != nil {
+			t.Fatalf("authenticateToken() error = %v", err)
+		}
+
+		if want := []Permission{PermTorrentsRead, PermTokensAdmin}; !reflect.DeepEqual(principal.Permissions(), want) {
+			t.Errorf("Permissions() = %v, want %v", principal.Permissions(), want)
+		}
+	}
+}
+
+func TestCreateTokenWithToken(t *testing.T) {
+	api := newTokenTestApi(t)
+
+	// A user of the same name as the token must not lend the new token their role
+	err := api.users.Create("script", "password", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := createTestToken(api, &Principal{Name: "alice", Role: RoleAdmin}, `["torrents:read", "tokens:admin"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := api.authenticateToken(parent.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = createTestToken(api, principal, `["torrents:read"]`)
+	if code := statusCode(err); code != http.StatusForbidden || !strings.Contains(err.Error(), "API token cannot") {
+		t.Errorf("httpCreateToken() with a token = %v, want status %d", err, http.StatusForbidden)
+	}
+}
+
+func TestCreateTokenForwardedWithoutAccount(t *testing.T) {
+	api := newTokenTestApi(t)
+
+	// The principal of a reverse proxy authenticated user that only has the default role
+	_, err := createTestToken(api, &Principal{Name: "bob", Role: RoleAdmin}, `["torrents:read"]`)
+	if code := statusCode(err); code != http.StatusForbidden || !strings.Contains(err.Error(), "user account") {
+		t.Errorf("httpCreateToken() without a user account = %v, want status %d", err, http.StatusForbidden)
+	}
+
+	if tokens := api.tokens.List(); len(tokens) != 0 {
+		t.Errorf("%d tokens were created", len(tokens))
+	}
+}
+
+func TestTokenCreatorPermissions(t *testing.T) {
+	api := newTokenTestApi(t)
+
+	created, err := createTestToken(api, &Principal{Name: "alice", Role: RoleAdmin}, `["torrents:read", "users:admin"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scopes that the creator no longer has are removed
+	err = api.users.Update("alice", "", RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := api.authenticateToken(created.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []Permission{PermTorrentsRead}; !reflect.DeepEqual(principal.Permissions(), want) {
+		t.Errorf("Permissions() after demotion = %v, want %v", principal.Permissions(), want)
+	}
+
+	// The token stops working once its creator is deleted
+	err = api.users.Delete("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = api.authenticateToken(created.Token)
+	if code := statusCode(err); code != http.StatusUnauthorized {
+		t.Errorf("authenticateToken() after deletion = %v, want status %d", err, http.StatusUnauthorized)
+	}
+}
+
+// statusCode returns the HTTP status code of err, or zero if it does not have one.
+func statusCode(err error) int {
+	if httpError, ok := err.(HTTPError); ok {
+		return httpError.StatusCode()
+	}
+
+	return 0
+}
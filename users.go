@@ -0,0 +1,438 @@
+package storm
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/pbkdf2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// passwordHashIterations is the number of PBKDF2 iterations used for new password hashes.
+	passwordHashIterations = 210000
+	passwordHashScheme     = "pbkdf2-sha256"
+
+	// verifiedCacheTTL is how long a successful password check is remembered for.
+	// Clients using Basic authentication send the password with every request, so without the cache each request would derive a key.
+	verifiedCacheTTL = time.Minute * 5
+	// verifiedCacheSize is the maximum number of remembered password checks.
+	verifiedCacheSize = 1024
+)
+
+// hashPassword hashes a password with a random salt.
+// The result is in the form pbkdf2-sha256$iterations$salt$hash.
+func hashPassword(password string) (string, error) {
+	var salt [16]byte
+	_, err := rand.Read(salt[:])
+	if err != nil {
+		return "", err
+	}
+
+	key := pbkdf2.Key([]byte(password), salt[:], passwordHashIterations, sha256.Size, sha256.New)
+
+	return fmt.Sprintf("%s$%d$%s$%s",
+		passwordHashScheme,
+		passwordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt[:]),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword checks that password matches a hash created by hashPassword.
+func verifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// User is a Storm user account.
+type User struct {
+	Name         string
+	Role         Role
+	PasswordHash string
+}
+
+type usersFile struct {
+	Users []*User
+}
+
+// OpenUserStore opens the file-backed user store at path.
+// The file is created when the first user is added.
+func OpenUserStore(path string) (*UserStore, error) {
+	var f usersFile
+
+	err := loadJSON(path, &f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users from %s: %w", path, err)
+	}
+
+	store := &UserStore{
+		path:     path,
+		users:    make(map[string]*User),
+		verified: make(map[[sha256.Size]byte]time.Time),
+	}
+
+	_, err = rand.Read(store.verifiedKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range f.Users {
+		store.users[u.Name] = u
+	}
+
+	store.dummyHash, err = hashPassword("")
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// UserStore is a file-backed store of user accounts.
+type UserStore struct {
+	path string
+	// dummyHash is verified against when authenticating an unknown user
+	dummyHash string
+
+	mu    sync.RWMutex
+	users map[string]*User
+
+	// verified remembers successful password checks until they expire.
+	// Entries are keyed by a keyed hash of the user, their password hash and the password, so they are never matched again once the password changes.
+	verifiedKey [32]byte
+	verifiedMu  sync.Mutex
+	verified    map[[sha256.Size]byte]time.Time
+}
+
+func (s *UserStore) verifiedCacheKey(u User, password string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, s.verifiedKey[:])
+	for _, v := range []string{u.Name, u.PasswordHash, password} {
+		mac.Write([]byte(v))
+		mac.Write([]byte{0})
+	}
+
+	var key [sha256.Size]byte
+	mac.Sum(key[:0])
+	return key
+}
+
+// verifyCached is verifyPassword for a user, remembering a successful check for verifiedCacheTTL.
+func (s *UserStore) verifyCached(u User, password string) bool {
+	key := s.verifiedCacheKey(u, password)
+	now := time.Now()
+
+	s.verifiedMu.Lock()
+	expires, ok := s.verified[key]
+	s.verifiedMu.Unlock()
+
+	if ok && now.Before(expires) {
+		return true
+	}
+
+	if !verifyPassword(u.PasswordHash, password) {
+		return false
+	}
+
+	s.verifiedMu.Lock()
+	defer s.verifiedMu.Unlock()
+
+	if len(s.verified) >= verifiedCacheSize {
+		for k, expires := range s.verified {
+			if !now.Before(expires) {
+				delete(s.verified, k)
+			}
+		}
+	}
+	if len(s.verified) >= verifiedCacheSize {
+		s.verified = make(map[[sha256.Size]byte]time.Time)
+	}
+
+	s.verified[key] = now.Add(verifiedCacheTTL)
+	return true
+}
+
+// save persists the store. The caller must hold the write lock.
+func (s *UserStore) save() error {
+	f := usersFile{
+		Users: make([]*User, 0, len(s.users)),
+	}
+
+	for _, u := range s.users {
+		f.Users = append(f.Users, u)
+	}
+
+	sort.Slice(f.Users, func(i, j int) bool {
+		return f.Users[i].Name < f.Users[j].Name
+	})
+
+	return saveJSON(s.path, &f)
+}
+
+// Len returns the number of users in the store.
+func (s *UserStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.users)
+}
+
+// Get gets a user by name.
+func (s *UserStore) Get(name string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, false
+	}
+
+	return *u, true
+}
+
+// Authenticate checks the password of the named user.
+func (s *UserStore) Authenticate(name, password string) (User, bool) {
+	u, ok := s.Get(name)
+	if !ok {
+		// Take the same amount of time as a known user to avoid leaking which users exist
+		verifyPassword(s.dummyHash, password)
+		return User{}, false
+	}
+
+	if !s.verifyCached(u, password) {
+		return User{}, false
+	}
+
+	return u, true
+}
+
+// List returns all users sorted by name.
+func (s *UserStore) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	return users
+}
+
+// Create adds a new user to the store.
+func (s *UserStore) Create(name, password string, role Role) error {
+	if name == "" || strings.ContainsAny(name, ":/") {
+		return &Error{Code: http.StatusBadRequest, Message: "User name must not be empty or contain ':' or '/'"}
+	}
+	if password == "" {
+		return &Error{Code: http.StatusBadRequest, Message: "Password must not be empty"}
+	}
+	if !role.Valid() {
+		return &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unknown role %q", role)}
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[name]; ok {
+		return &Error{Code: http.StatusConflict, Message: fmt.Sprintf("User %q already exists", name)}
+	}
+
+	s.users[name] = &User{
+		Name:         name,
+		Role:         role,
+		PasswordHash: hash,
+	}
+
+	err = s.save()
+	if err != nil {
+		delete(s.users, name)
+	}
+
+	return err
+}
+
+// Update changes the password and/or role of an existing user.
+// Empty values are left unchanged.
+func (s *UserStore) Update(name, password string, role Role) error {
+	if role != "" && !role.Valid() {
+		return &Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Unknown role %q", role)}
+	}
+
+	var hash string
+	if password != "" {
+		var err error
+		hash, err = hashPassword(password)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[name]
+	if !ok {
+		return &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("User %q does not exist", name)}
+	}
+
+	updated := *u
+	if hash != "" {
+		updated.PasswordHash = hash
+	}
+	if role != "" {
+		updated.Role = role
+	}
+
+	s.users[name] = &updated
+
+	err := s.save()
+	if err != nil {
+		s.users[name] = u
+	}
+
+	return err
+}
+
+// Delete removes a user from the store.
+func (s *UserStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[name]
+	if !ok {
+		return &Error{Code: http.StatusNotFound, Message: fmt.Sprintf("User %q does not exist", name)}
+	}
+
+	delete(s.users, name)
+
+	err := s.save()
+	if err != nil {
+		s.users[name] = u
+	}
+
+	return err
+}
+
+type UserResponse struct {
+	Name string
+	Role Role
+}
+
+type CreateUserRequest struct {
+	Name     string
+	Password string
+	Role     Role
+}
+
+type UpdateUserRequest struct {
+	Password string
+	Role     Role
+}
+
+// httpUsers lists all users
+func (api *Api) httpUsers(_ *http.Request) (interface{}, error) {
+	var (
+		users    = api.users.List()
+		response = make([]UserResponse, 0, len(users))
+	)
+
+	for _, u := range users {
+		response = append(response, UserResponse{Name: u.Name, Role: u.Role})
+	}
+
+	return response, nil
+}
+
+// httpCreateUser creates a new user
+func (api *Api) httpCreateUser(r *http.Request) (interface{}, error) {
+	var req CreateUserRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = api.users.Create(req.Name, req.Password, req.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	return UserResponse{Name: req.Name, Role: req.Role}, nil
+}
+
+// httpUpdateUser changes the password and/or role of a user
+func (api *Api) httpUpdateUser(r *http.Request) (interface{}, error) {
+	var req UpdateUserRequest
+
+	err := Read(r, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := mux.Vars(r)["name"]
+
+	err = api.users.Update(name, req.Password, req.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	// Log the user out everywhere when their password changes
+	if req.Password != "" {
+		api.sessions.RevokeUser(name)
+	}
+
+	return nil, nil
+}
+
+// httpDeleteUser deletes a user
+func (api *Api) httpDeleteUser(r *http.Request) (interface{}, error) {
+	name := mux.Vars(r)["name"]
+
+	err := api.users.Delete(name)
+	if err != nil {
+		return nil, err
+	}
+
+	api.sessions.RevokeUser(name)
+
+	return nil, nil
+}
@@ -0,0 +1,453 @@
+package storm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"go.uber.org/zap"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWatchInterval is how often watch folders are scanned if not configured.
+	DefaultWatchInterval = time.Second * 10
+
+	// WatchDoneFolder is the subfolder of a watch folder that files are moved to once added.
+	WatchDoneFolder = "done"
+	// WatchFailedFolder is the subfolder of a watch folder that files are moved to if they could not be added.
+	WatchFailedFolder = "failed"
+	// watchErrorSuffix is appended to the name of a failed file to name the file containing its error.
+	watchErrorSuffix = ".error.txt"
+
+	// watchMaxFileSize is the largest file that will be read from a watch folder.
+	watchMaxFileSize = 32 << 20
+	// watchLogSize is the number of processed files kept in the log.
+	watchLogSize = 100
+	// watchMaxDuplicates is the number of files of the same name that can be moved into the done or failed subfolder.
+	watchMaxDuplicates = 10000
+)
+
+// WatchFolder is a directory that .torrent and .magnet files are added from.
+type WatchFolder struct {
+	Path string
+	// Daemon is the daemon that torrents are added to, or the default daemon if empty
+	Daemon string
+	// Label is applied to torrents after they are added if not empty
+	Label   string
+	Options deluge.Options
+}
+
+// LoadWatchFolders reads the list of watch folders from the JSON file at path.
+func LoadWatchFolders(path string) ([]WatchFolder, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []WatchFolder
+
+	err = json.Unmarshal(b, &folders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watch folders from %s: %w", path, err)
+	}
+
+	for _, folder := range folders {
+		if !filepath.IsAbs(folder.Path) {
+			return nil, fmt.Errorf("watch folder %q in %s must be an absolute path", folder.Path, path)
+		}
+
+		info, err := os.Stat(folder.Path)
+		if err != nil {
+			return nil, fmt.Errorf("watch folder %q in %s: %w", folder.Path, path, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("watch folder %q in %s is not a directory", folder.Path, path)
+		}
+	}
+
+	return folders, nil
+}
+
+// WatchResult is the outcome of adding a file from a watch folder.
+type WatchResult struct {
+	Time    time.Time
+	Folder  string
+	File    string
+	Daemon  string
+	Torrent string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// WatchConfig configures watch folders.
+type WatchConfig struct {
+	Folders []WatchFolder
+	// Interval is how often folders are scanned, defaults to DefaultWatchInterval
+	Interval time.Duration
+}
+
+type watchFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewWatcher creates a new Watcher. Call Start to begin scanning folders.
+func NewWatcher(log *zap.Logger, daemons *Daemons, config WatchConfig) *Watcher {
+	if config.Interval <= 0 {
+		config.Interval = DefaultWatchInterval
+	}
+
+	return &Watcher{
+		log:     log,
+		daemons: daemons,
+		config:  config,
+		pending: make(map[string]watchFileState),
+	}
+}
+
+// Watcher scans watch folders and adds the torrents of any .torrent or .magnet files within them.
+type Watcher struct {
+	log     *zap.Logger
+	daemons *Daemons
+	config  WatchConfig
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// pending is the state of each file when it was last scanned.
+	// A file is only added once it has stopped changing between scans so that it is not read while still being copied.
+	pending map[string]watchFileState
+
+	mu      sync.Mutex
+	results []*WatchResult
+}
+
+// Start starts scanning folders in the background.
+func (w *Watcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			w.scan(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops scanning folders and waits for any file being added to finish.
+func (w *Watcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+
+	w.cancel()
+	w.wg.Wait()
+}
+
+// Results returns the most recently processed files, newest first.
+func (w *Watcher) Results() []*WatchResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	results := make([]*WatchResult, 0, len(w.results))
+	for i := len(w.results) - 1; i >= 0; i-- {
+		result := *w.results[i]
+		results = append(results, &result)
+	}
+
+	return results
+}
+
+func (w *Watcher) record(result *WatchResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.results = append(w.results, result)
+	if len(w.results) > watchLogSize {
+		w.results = w.results[len(w.results)-watchLogSize:]
+	}
+}
+
+// isWatchFile returns true if name is a .torrent or .magnet file.
+func isWatchFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".torrent", ".magnet":
+		return true
+	}
+
+	return false
+}
+
+// scan adds the files of every watch folder that have not changed since the previous scan.
+func (w *Watcher) scan(ctx context.Context) {
+	seen := make(map[string]bool)
+
+	for i := range w.config.Folders {
+		folder := &w.config.Folders[i]
+
+		entries, err := os.ReadDir(folder.Path)
+		if err != nil {
+			w.log.Error("Failed to scan watch folder", zap.String("Folder", folder.Path), zap.Error(err))
+			continue
+		}
+
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !entry.Type().IsRegular() || !isWatchFile(entry.Name()) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+
+			var (
+				file  = filepath.Join(folder.Path, entry.Name())
+				state = watchFileState{size: info.Size(), modTime: info.ModTime()}
+			)
+
+			seen[file] = true
+
+			if previous, ok := w.pending[file]; !ok || previous != state {
+				w.pending[file] = state
+				continue
+			}
+
+			w.process(ctx, folder, file)
+		}
+	}
+
+	for file := range w.pending {
+		if !seen[file] {
+			delete(w.pending, file)
+		}
+	}
+}
+
+// process adds the torrent of file to Deluge and moves the file to the done or failed subfolder.
+// If the daemon could not be reached then the file is left in place to be retried on the next scan.
+func (w *Watcher) process(ctx context.Context, folder *WatchFolder, file string) {
+	daemon := folder.Daemon
+	if daemon == "" {
+		daemon = w.daemons.Default()
+	}
+
+	result := &WatchResult{
+		Time:   time.Now().UTC(),
+		Folder: folder.Path,
+		File:   filepath.Base(file),
+		Daemon: daemon,
+	}
+
+	pool, err := w.daemons.Get(daemon)
+	if err == nil {
+		err = pool.Do(ctx, func(conn deluge.DelugeClient) error {
+			id, err := addWatchFile(conn, folder, file)
+			if err != nil {
+				return err
+			}
+
+			result.Torrent = id
+
+			if folder.Label != "" {
+				plugin, err := labelPluginClient(conn)
+				if err == nil {
+					err = plugin.SetTorrentLabel(id, folder.Label)
+				}
+				if err != nil {
+					w.log.Warn("Failed to set label of torrent added from watch folder", zap.String("File", file), zap.String("Torrent", id), zap.Error(rpcError(err)))
+				}
+			}
+
+			return nil
+		})
+	}
+
+	// Invalid files and torrents rejected by Deluge are failed, any other error is retried on the next scan.
+	switch err.(type) {
+	case nil:
+	case *watchFileError:
+		result.Error = err.Error()
+	case deluge.RPCError:
+		result.Error = rpcError(err).Error()
+	default:
+		w.log.Error("Failed to add torrent from watch folder, will retry", zap.String("File", file), zap.Error(err))
+		return
+	}
+
+	delete(w.pending, file)
+
+	subfolder := WatchDoneFolder
+	if result.Error != "" {
+		subfolder = WatchFailedFolder
+	}
+
+	dest, err := moveWatchFile(file, filepath.Join(folder.Path, subfolder))
+	if err == nil && result.Error != "" {
+		err = os.WriteFile(dest+watchErrorSuffix, []byte(result.Error+"\n"), 0644)
+	}
+	if err != nil {
+		w.log.Error("Failed to move file out of watch folder", zap.String("File", file), zap.Error(err))
+	}
+
+	w.log.Info("Processed file from watch folder",
+		zap.String("File", file),
+		zap.String("Torrent", result.Torrent),
+		zap.String("Error", result.Error),
+	)
+
+	w.record(result)
+}
+
+// watchFileError is returned when a file in a watch folder is not a valid torrent or magnet file.
+type watchFileError struct {
+	message string
+}
+
+func (e *watchFileError) Error() string {
+	return e.message
+}
+
+// readMagnetFile reads the first line of a .magnet file, which must be a magnet URI.
+func readMagnetFile(b []byte) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "magnet:") {
+			break
+		}
+
+		return line, nil
+	}
+
+	return "", &watchFileError{message: "File does not contain a magnet URI"}
+}
+
+// addWatchFile adds the torrent of a .torrent or .magnet file to Deluge.
+func addWatchFile(conn deluge.DelugeClient, folder *WatchFolder, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", &watchFileError{message: err.Error()}
+	}
+
+	b, err := io.ReadAll(io.LimitReader(f, watchMaxFileSize+1))
+	_ = f.Close()
+	if err != nil {
+		return "", &watchFileError{message: err.Error()}
+	}
+
+	if len(b) > watchMaxFileSize {
+		return "", &watchFileError{message: "File is too large"}
+	}
+
+	var (
+		options = folder.Options
+		id      string
+	)
+
+	if strings.EqualFold(filepath.Ext(file), ".magnet") {
+		var uri string
+		uri, err = readMagnetFile(b)
+		if err != nil {
+			return "", err
+		}
+
+		id, err = conn.AddTorrentMagnet(uri, &options)
+	} else {
+		id, err = conn.AddTorrentFile(filepath.Base(file), base64.StdEncoding.EncodeToString(b), &options)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// The RPC returns an empty ID if the torrent could not be parsed or processed.
+	if id == "" {
+		return "", &watchFileError{message: "Torrent file could not be read"}
+	}
+
+	return id, nil
+}
+
+// moveWatchFile moves file into dir, creating dir if needed.
+// If a file of the same name already exists in dir then a number is added to the name, such as name-1.torrent.
+func moveWatchFile(file, dir string) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		base = filepath.Base(file)
+		ext  = filepath.Ext(base)
+		name = strings.TrimSuffix(base, ext)
+	)
+
+	for i := 0; i <= watchMaxDuplicates; i++ {
+		dest := filepath.Join(dir, base)
+		if i > 0 {
+			dest = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, i, ext))
+		}
+
+		// Reserve the name first so that an existing file is never replaced by the rename
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_ = f.Close()
+
+		err = os.Rename(file, dest)
+		if err != nil {
+			_ = os.Remove(dest)
+			return "", err
+		}
+
+		return dest, nil
+	}
+
+	return "", fmt.Errorf("too many files named %s in %s", base, dir)
+}
+
+// httpWatchResults gets the most recently processed files from watch folders
+func (api *Api) httpWatchResults(_ *http.Request) (interface{}, error) {
+	return api.watcher.Results(), nil
+}
@@ -0,0 +1,49 @@
+package storm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveWatchFile(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		done = filepath.Join(dir, WatchDoneFolder)
+	)
+
+	// Each file of the same name is moved without replacing the files moved before it
+	want := []string{"show.torrent", "show-1.torrent", "show-2.torrent"}
+	for i, name := range want {
+		file := filepath.Join(dir, "show.torrent")
+
+		err := os.WriteFile(file, []byte{byte(i)}, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dest, err := moveWatchFile(file, done)
+		if err != nil {
+			t.Fatalf("moveWatchFile() error = %v", err)
+		}
+
+		if dest != filepath.Join(done, name) {
+			t.Errorf("moveWatchFile() = %s, want %s", dest, filepath.Join(done, name))
+		}
+
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after being moved", file)
+		}
+	}
+
+	for i, name := range want {
+		b, err := os.ReadFile(filepath.Join(done, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(b) != 1 || b[0] != byte(i) {
+			t.Errorf("%s has the content of another file", name)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package storm
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	deluge "github.com/gdm85/go-libdeluge"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	TorrentEventAdded    = "added"
+	TorrentEventFinished = "finished"
+	TorrentEventError    = "error"
+	TorrentEventRemoved  = "removed"
+
+	// WebhookSignatureHeader contains the hex encoded HMAC-SHA256 signature of the request body.
+	WebhookSignatureHeader = "X-Storm-Signature"
+
+	// webhookQueueSize is the maximum number of events waiting to be delivered to each URL.
+	webhookQueueSize = 256
+	// webhookLogSize is the number of deliveries kept in the delivery log.
+	webhookLogSize = 100
+)
+
+// TorrentEvent is a change in the lifecycle of a torrent.
+type TorrentEvent struct {
+	ID      string
+	Event   string
+	Time    time.Time
+	Torrent *ViewTorrent
+}
+
+func randomID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// reachableDaemons returns the names of daemons that were successfully polled in the view.
+func reachableDaemons(update *ViewUpdate) map[string]bool {
+	reachable := make(map[string]bool, len(update.Daemons))
+	for name, d := range update.Daemons {
+		reachable[name] = d.Error == ""
+	}
+
+	return reachable
+}
+
+// detectTorrentEvents compares two successive views and returns the lifecycle events that occurred between them.
+// Torrents on daemons that could not be polled in either view are ignored.
+func detectTorrentEvents(previous, next *ViewUpdate) []*TorrentEvent {
+	var (
+		events    []*TorrentEvent
+		now       = time.Now().UTC()
+		before    = indexTorrents(previous)
+		after     = indexTorrents(next)
+		wasPolled = reachableDaemons(previous)
+		isPolled  = reachableDaemons(next)
+	)
+
+	event := func(name string, t *ViewTorrent) {
+		events = append(events, &TorrentEvent{
+			ID:      randomID(),
+			Event:   name,
+			Time:    now,
+			Torrent: t,
+		})
+	}
+
+	for _, t := range next.Torrents {
+		if !wasPolled[t.Daemon] || !isPolled[t.Daemon] {
+			continue
+		}
+
+		old, ok := before[TorrentRef{Daemon: t.Daemon, Hash: t.Hash}]
+		if !ok {
+			event(TorrentEventAdded, t)
+			continue
+		}
+
+		if old.Progress < 100 && t.Progress >= 100 {
+			event(TorrentEventFinished, t)
+		}
+
+		if old.State != string(deluge.StateError) && t.State == string(deluge.StateError) {
+			event(TorrentEventError, t)
+		}
+	}
+
+	for _, t := range previous.Torrents {
+		if !wasPolled[t.Daemon] || !isPolled[t.Daemon] {
+			continue
+		}
+
+		if _, ok := after[TorrentRef{Daemon: t.Daemon, Hash: t.Hash}]; !ok {
+			event(TorrentEventRemoved, t)
+		}
+	}
+
+	return events
+}
+
+// WebhookConfig configures outgoing webhooks.
+type WebhookConfig struct {
+	// URLs receive a POST request for each torrent event
+	URLs []string
+	// Secret is used to sign the request body. Requests are not signed if empty.
+	Secret string
+	// Retries is the maximum number of times a failed delivery is retried
+	Retries int
+	// Backoff is the delay before the first retry, doubling for each subsequent retry
+	Backoff time.Duration
+	// Timeout is the timeout of a single delivery attempt
+	Timeout time.Duration
+}
+
+// WebhookDelivery records the outcome of delivering an event to a webhook URL.
+type WebhookDelivery struct {
+	ID         string
+	Event      string
+	URL        string
+	Time       time.Time
+	Attempts   int
+	StatusCode int    `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	Delivered  bool
+}
+
+type webhookJob struct {
+	url   string
+	event *TorrentEvent
+}
+
+// NewWebhooks creates a new Webhooks dispatcher.
+// Call Start to begin detecting events from the stream.
+func NewWebhooks(log *zap.Logger, stream *ViewStream, config WebhookConfig) *Webhooks {
+	// Each URL has its own queue so that a slow or failing URL does not delay delivery to the others
+	queues := make(map[string]chan *webhookJob, len(config.URLs))
+	for _, url := range config.URLs {
+		queues[url] = make(chan *webhookJob, webhookQueueSize)
+	}
+
+	return &Webhooks{
+		Log:    log,
+		Config: config,
+		Client: &http.Client{Timeout: config.Timeout},
+		stream: stream,
+		queues: queues,
+	}
+}
+
+// Webhooks detects torrent lifecycle events from a ViewStream and delivers them to the configured URLs.
+type Webhooks struct {
+	Log    *zap.Logger
+	Config WebhookConfig
+	Client *http.Client
+
+	stream *ViewStream
+	queues map[string]chan *webhookJob
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	log []*WebhookDelivery
+}
+
+// Start starts detecting and delivering events in the background.
+func (w *Webhooks) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.wg.Add(1 + len(w.queues))
+	go w.detect(ctx)
+
+	for _, queue := range w.queues {
+		go w.deliver(ctx, queue)
+	}
+}
+
+// Stop stops detecting events and waits for any in-progress delivery to finish.
+func (w *Webhooks) Stop() {
+	if w.cancel == nil {
+		return
+	}
+
+	w.cancel()
+	w.wg.Wait()
+}
+
+// Deliveries returns the most recent deliveries, newest first.
+func (w *Webhooks) Deliveries() []*WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	deliveries := make([]*WebhookDelivery, 0, len(w.log))
+	for i := len(w.log) - 1; i >= 0; i-- {
+		d := *w.log[i]
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries
+}
+
+func (w *Webhooks) record(d *WebhookDelivery) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.log = append(w.log, d)
+	if len(w.log) > webhookLogSize {
+		w.log = w.log[len(w.log)-webhookLogSize:]
+	}
+}
+
+func (w *Webhooks) detect(ctx context.Context) {
+	defer w.wg.Done()
+
+	for {
+		var (
+			sub      = w.stream.Subscribe()
+			previous = sub.Initial
+		)
+
+	Events:
+		for {
+			select {
+			case <-ctx.Done():
+				w.stream.Unsubscribe(sub)
+				return
+			case event, ok := <-sub.Events:
+				if !ok {
+					// The subscription fell behind, subscribe again
+					w.Log.Warn("Webhook event detection fell behind the view stream, events may have been missed")
+					break Events
+				}
+
+				if event.Update == nil {
+					continue
+				}
+
+				if previous != nil {
+					for _, e := range detectTorrentEvents(previous, event.Update) {
+						w.enqueue(e)
+					}
+				}
+
+				previous = event.Update
+			}
+		}
+	}
+}
+
+func (w *Webhooks) enqueue(event *TorrentEvent) {
+	for url, queue := range w.queues {
+		select {
+		case queue <- &webhookJob{url: url, event: event}:
+		default:
+			w.Log.Error("Webhook queue is full, dropping event", zap.String("Event", event.Event), zap.String("URL", url))
+		}
+	}
+}
+
+// deliver delivers the events of a single URL's queue in order.
+func (w *Webhooks) deliver(ctx context.Context, queue <-chan *webhookJob) {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-queue:
+			d := w.send(ctx, job)
+			w.record(d)
+
+			if !d.Delivered {
+				w.Log.Error("Failed to deliver webhook", zap.String("Event", d.Event), zap.String("URL", d.URL), zap.String("Error", d.Error))
+			}
+		}
+	}
+}
+
+// send delivers a single event to a webhook URL, retrying with exponential backoff on failure.
+func (w *Webhooks) send(ctx context.Context, job *webhookJob) *WebhookDelivery {
+	d := &WebhookDelivery{
+		ID:    job.event.ID,
+		Event: job.event.Event,
+		URL:   job.url,
+		Time:  time.Now().UTC(),
+	}
+
+	body, err := json.Marshal(job.event)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	var backoff = w.Config.Backoff
+	for {
+		d.Attempts++
+		d.StatusCode, err = w.post(ctx, job, body)
+		if err == nil {
+			d.Delivered = true
+			d.Error = ""
+			return d
+		}
+
+		d.Error = err.Error()
+		if d.Attempts > w.Config.Retries {
+			return d
+		}
+
+		select {
+		case <-ctx.Done():
+			return d
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (w *Webhooks) post(ctx context.Context, job *webhookJob, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Storm-Event", job.event.Event)
+	req.Header.Set("X-Storm-Delivery", job.event.ID)
+
+	if w.Config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Config.Secret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil))))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// httpWebhookDeliveries gets the most recent webhook deliveries
+func (api *Api) httpWebhookDeliveries(_ *http.Request) (interface{}, error) {
+	return api.webhooks.Deliveries(), nil
+}
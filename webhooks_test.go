@@ -0,0 +1,97 @@
+package storm
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDetectTorrentEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous *ViewUpdate
+		next     *ViewUpdate
+		want     []string
+	}{
+		{
+			name:     "added",
+			previous: testViewUpdate([]string{"a"}, nil),
+			next:     testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Downloading", 0)),
+			want:     []string{"added a/1"},
+		},
+		{
+			name:     "finished",
+			previous: testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Downloading", 99.5)),
+			next:     testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Seeding", 100)),
+			want:     []string{"finished a/1"},
+		},
+		{
+			name:     "already finished",
+			previous: testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Seeding", 100)),
+			next:     testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Paused", 100)),
+		},
+		{
+			name:     "error",
+			previous: testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Downloading", 10)),
+			next:     testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Error", 10)),
+			want:     []string{"error a/1"},
+		},
+		{
+			name:     "still in error",
+			previous: testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Error", 10)),
+			next:     testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Error", 10)),
+		},
+		{
+			name:     "finished with error",
+			previous: testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Downloading", 90)),
+			next:     testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Error", 100)),
+			want:     []string{"error a/1", "finished a/1"},
+		},
+		{
+			name:     "removed",
+			previous: testViewUpdate([]string{"a"}, nil, testViewTorrent("a", "1", "Seeding", 100)),
+			next:     testViewUpdate([]string{"a"}, nil),
+			want:     []string{"removed a/1"},
+		},
+		{
+			name:     "same hash on another daemon",
+			previous: testViewUpdate([]string{"a", "b"}, nil, testViewTorrent("a", "1", "Seeding", 100)),
+			next:     testViewUpdate([]string{"a", "b"}, nil, testViewTorrent("b", "1", "Downloading", 0)),
+			want:     []string{"added b/1", "removed a/1"},
+		},
+		{
+			name:     "daemon became unreachable",
+			previous: testViewUpdate([]string{"a", "b"}, nil, testViewTorrent("a", "1", "Seeding", 100), testViewTorrent("b", "2", "Seeding", 100)),
+			next:     testViewUpdate([]string{"a"}, []string{"b"}, testViewTorrent("a", "1", "Seeding", 100)),
+		},
+		{
+			name:     "daemon became reachable",
+			previous: testViewUpdate([]string{"a"}, []string{"b"}, testViewTorrent("a", "1", "Seeding", 100)),
+			next:     testViewUpdate([]string{"a", "b"}, nil, testViewTorrent("a", "1", "Seeding", 100), testViewTorrent("b", "2", "Seeding", 100)),
+		},
+		{
+			name:     "daemon added to the view",
+			previous: testViewUpdate([]string{"a"}, nil),
+			next:     testViewUpdate([]string{"a", "b"}, nil, testViewTorrent("b", "2", "Seeding", 100)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, event := range detectTorrentEvents(tt.previous, tt.next) {
+				if event.ID == "" || event.Time.IsZero() {
+					t.Errorf("event %+v does not have an ID and time", event)
+				}
+
+				got = append(got, event.Event+" "+event.Torrent.Daemon+"/"+event.Torrent.Hash)
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("detectTorrentEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}